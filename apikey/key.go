@@ -0,0 +1,168 @@
+/*
+Package apikey provides long lived, revocable API keys for CI pipelines and other automation clients.
+
+A key has the form ddev_<id>_<secret>. The id is public and names the stored record, the secret is only retained as
+its SHA-256 hash. Clients supply keys with the Basic authorization scheme, either as the username of the full key and
+an empty password, or with the id as the username and the secret as the password. The Store verifies them once
+registered with the state interceptors:
+
+	interceptors.WithSchemeVerifier(apictx.SchemeBasic, apikey.NewStore(client))
+*/
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	fbauth "firebase.google.com/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// Prefix marks API keys, e.g. for secret scanners
+const Prefix = "ddev"
+
+const (
+	idBytes     = 8
+	secretBytes = 32
+)
+
+// generate returns a new key and its id and secret
+func generate() (key, id, secret string, err error) {
+	b := make([]byte, idBytes+secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	id = hex.EncodeToString(b[:idBytes])
+	secret = base64.RawURLEncoding.EncodeToString(b[idBytes:])
+	return Format(id, secret), id, secret, nil
+}
+
+// newKey returns a new key for the user and its record created at the time, see Store.Create
+func newKey(uid, name string, workspaces []string, ttl time.Duration, now time.Time) (string, *Key, error) {
+	if uid == "" {
+		return "", nil, status.Error(codes.InvalidArgument, "api keys require a user")
+	}
+	if ttl < 0 {
+		return "", nil, status.Error(codes.InvalidArgument, "api key ttl must not be negative")
+	}
+	key, id, secret, err := generate()
+	if err != nil {
+		return "", nil, status.Errorf(codes.Internal, "unable to generate api key: %v", err)
+	}
+	record := &Key{
+		Id:         id,
+		Uid:        uid,
+		Name:       name,
+		Hash:       hash(secret),
+		Workspaces: workspaces,
+		CreateTime: timestamppb.New(now),
+	}
+	if ttl > 0 {
+		record.ExpireTime = timestamppb.New(now.Add(ttl))
+	}
+	return key, record, nil
+}
+
+// Format returns the key of an id and secret
+func Format(id, secret string) string {
+	return fmt.Sprintf("%s_%s_%s", Prefix, id, secret)
+}
+
+// Parse splits a key into its id and secret
+func Parse(key string) (id, secret string, err error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != Prefix || parts[1] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("malformed api key")
+	}
+	return parts[1], parts[2], nil
+}
+
+// BasicCredentials returns the credentials of a key for the Basic authorization scheme
+func BasicCredentials(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key + ":"))
+}
+
+// parseBasic returns the id and secret of Basic authorization credentials
+func parseBasic(credentials string) (id, secret string, err error) {
+	decoded, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return "", "", fmt.Errorf("malformed basic credentials: %v", err)
+	}
+	user, password := string(decoded), ""
+	if idx := strings.IndexByte(user, ':'); idx >= 0 {
+		user, password = user[:idx], user[idx+1:]
+	}
+	if password == "" {
+		return Parse(user)
+	}
+	if user == "" {
+		return "", "", fmt.Errorf("malformed basic credentials: no key id")
+	}
+	return user, password, nil
+}
+
+// hash returns the stored hash of a secret, the secrets are random so need no salt or stretching
+func hash(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// matches reports whether the secret hashes to the stored hash
+func (x *Key) matches(secret string) bool {
+	return subtle.ConstantTimeCompare(hash(secret), x.GetHash()) == 1
+}
+
+// check returns an error unless the secret matches the record and the key has not expired at the time
+func (x *Key) check(secret string, now time.Time) error {
+	if !x.matches(secret) {
+		return fmt.Errorf("api key %s secret does not match", x.GetId())
+	}
+	if expire := x.GetExpireTime(); expire != nil && !now.Before(expire.AsTime()) {
+		return fmt.Errorf("api key %s expired at %s", x.GetId(), expire.AsTime().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// stale reports whether the last used time of the record is due to be written, once the interval has passed
+func (x *Key) stale(now time.Time, interval time.Duration) bool {
+	last := x.GetLastUsedTime()
+	return last == nil || now.Sub(last.AsTime()) >= interval
+}
+
+// token returns a token for the user the key belongs to. Scoped keys carry their workspaces in the workspaces claim,
+// a key scoped to a single workspace defaults to it.
+func (x *Key) token() *fbauth.Token {
+	claims := map[string]interface{}{
+		apimeta.ClaimKeyAPIKey: x.GetId(),
+	}
+	if workspaces := x.GetWorkspaces(); len(workspaces) > 0 {
+		scopes := make([]interface{}, len(workspaces))
+		for n, ws := range workspaces {
+			scopes[n] = ws
+		}
+		claims[apimeta.ClaimKeyWorkspaces] = scopes
+		if len(workspaces) == 1 {
+			claims[apimeta.ClaimKeyDefaultWorkspace] = workspaces[0]
+		}
+	}
+	token := &fbauth.Token{
+		Subject:  x.GetUid(),
+		UID:      x.GetUid(),
+		IssuedAt: x.GetCreateTime().AsTime().Unix(),
+		Claims:   claims,
+	}
+	if expire := x.GetExpireTime(); expire != nil {
+		token.Expires = expire.AsTime().Unix()
+	}
+	return token
+}
@@ -0,0 +1,179 @@
+package apikey
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func TestGenerate(t *testing.T) {
+	key, id, secret, err := generate()
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+	gotID, gotSecret, err := Parse(key)
+	if err != nil || gotID != id || gotSecret != secret {
+		t.Errorf("Parse(%s) = %q, %q, %v", key, gotID, gotSecret, err)
+	}
+	record := &Key{Id: id, Hash: hash(secret)}
+	if !record.matches(secret) || record.matches(secret+"x") {
+		t.Errorf("matches() does not compare the secret hash")
+	}
+	if other, _, _, _ := generate(); other == key {
+		t.Errorf("generate() returned the same key twice")
+	}
+}
+
+func TestParseBasic(t *testing.T) {
+	key := Format("0123456789abcdef", "s3cr_t-value")
+	basic := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name        string
+		credentials string
+		wantID      string
+		wantSecret  string
+		wantErr     bool
+	}{
+		{"key as username", BasicCredentials(key), "0123456789abcdef", "s3cr_t-value", false},
+		{"key without password separator", basic(key), "0123456789abcdef", "s3cr_t-value", false},
+		{"id and secret", basic("0123456789abcdef:s3cr_t-value"), "0123456789abcdef", "s3cr_t-value", false},
+		{"secret without id", basic(":s3cr_t-value"), "", "", true},
+		{"not a key", basic("alice:"), "", "", true},
+		{"other prefix", basic("ghp_0123456789abcdef_secret:"), "", "", true},
+		{"not base64", "not base64!", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, secret, err := parseBasic(tt.credentials)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBasic() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if id != tt.wantID || secret != tt.wantSecret {
+				t.Errorf("parseBasic() = %q, %q, want %q, %q", id, secret, tt.wantID, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	if _, _, err := newKey("", "ci", nil, 0, now); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("newKey() without a user error = %v, want InvalidArgument", err)
+	}
+	if _, _, err := newKey("alice", "ci", nil, -time.Hour, now); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("newKey() with a negative ttl error = %v, want InvalidArgument", err)
+	}
+
+	key, record, err := newKey("alice", "ci", []string{"acme.prod"}, time.Hour, now)
+	if err != nil {
+		t.Fatalf("newKey() error = %v", err)
+	}
+	id, secret, err := Parse(key)
+	if err != nil || id != record.GetId() || record.check(secret, now) != nil {
+		t.Errorf("newKey() key %s does not verify against its record", key)
+	}
+	if !record.GetCreateTime().AsTime().Equal(now) || !record.GetExpireTime().AsTime().Equal(now.Add(time.Hour)) {
+		t.Errorf("newKey() created %v, expires %v", record.GetCreateTime(), record.GetExpireTime())
+	}
+	if _, unlimited, _ := newKey("alice", "ci", nil, 0, now); unlimited.GetExpireTime() != nil {
+		t.Errorf("newKey() without a ttl expires %v", unlimited.GetExpireTime())
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		secret  string
+		expire  *timestamppb.Timestamp
+		wantErr bool
+	}{
+		{name: "valid", secret: "secret"},
+		{name: "valid until expiry", secret: "secret", expire: timestamppb.New(now.Add(time.Second))},
+		{name: "wrong secret", secret: "other", wantErr: true},
+		{name: "expired", secret: "secret", expire: timestamppb.New(now), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &Key{Id: "0123456789abcdef", Hash: hash("secret"), ExpireTime: tt.expire}
+			if err := record.check(tt.secret, now); (err != nil) != tt.wantErr {
+				t.Errorf("check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStale(t *testing.T) {
+	now := time.Now()
+	if !(&Key{}).stale(now, time.Minute) {
+		t.Errorf("stale() = false for a key never used")
+	}
+	record := &Key{LastUsedTime: timestamppb.New(now.Add(-30 * time.Second))}
+	if record.stale(now, time.Minute) {
+		t.Errorf("stale() = true within the interval")
+	}
+	if !record.stale(now, 30*time.Second) || !record.stale(now, 0) {
+		t.Errorf("stale() = false once the interval has passed")
+	}
+}
+
+func TestToken(t *testing.T) {
+	created := time.Now().Truncate(time.Second)
+	tests := []struct {
+		name       string
+		workspaces []string
+		expire     *timestamppb.Timestamp
+		want       map[string]interface{}
+		wantExpiry int64
+	}{
+		{
+			name: "unscoped",
+			want: map[string]interface{}{apimeta.ClaimKeyAPIKey: "0123456789abcdef"},
+		},
+		{
+			name:       "single workspace",
+			workspaces: []string{"acme.prod"},
+			expire:     timestamppb.New(created.Add(time.Hour)),
+			want: map[string]interface{}{
+				apimeta.ClaimKeyAPIKey:           "0123456789abcdef",
+				apimeta.ClaimKeyWorkspaces:       []interface{}{"acme.prod"},
+				apimeta.ClaimKeyDefaultWorkspace: "acme.prod",
+			},
+			wantExpiry: created.Add(time.Hour).Unix(),
+		},
+		{
+			name:       "multiple workspaces",
+			workspaces: []string{"acme.prod", "acme.staging"},
+			want: map[string]interface{}{
+				apimeta.ClaimKeyAPIKey:     "0123456789abcdef",
+				apimeta.ClaimKeyWorkspaces: []interface{}{"acme.prod", "acme.staging"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &Key{
+				Id:         "0123456789abcdef",
+				Uid:        "alice",
+				Workspaces: tt.workspaces,
+				CreateTime: timestamppb.New(created),
+				ExpireTime: tt.expire,
+			}
+			token := record.token()
+			if token.UID != "alice" || token.Subject != "alice" || token.IssuedAt != created.Unix() || token.Expires != tt.wantExpiry {
+				t.Errorf("token() = %+v", token)
+			}
+			if !reflect.DeepEqual(token.Claims, tt.want) {
+				t.Errorf("token() claims = %v, want %v", token.Claims, tt.want)
+			}
+		})
+	}
+}
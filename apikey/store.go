@@ -0,0 +1,188 @@
+package apikey
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	fbauth "firebase.google.com/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/klog"
+
+	"github.com/drud/api-common/state"
+)
+
+// DefaultLastUsedInterval is how often the last used time of a key in use is written by default
+const DefaultLastUsedInterval = 5 * time.Minute
+
+// Option configures the Store
+type Option func(*Store)
+
+// WithLastUsedInterval limits how often the last used time of a key is written, every verification is written when 0
+func WithLastUsedInterval(interval time.Duration) Option {
+	return func(s *Store) {
+		s.lastUsedInterval = interval
+	}
+}
+
+// Store creates, verifies and revokes the API keys serialized to the apikeys Firestore collection
+type Store struct {
+	client           *firestore.Client
+	lastUsedInterval time.Duration
+	now              func() time.Time
+}
+
+// NewStore returns a Store reading and writing keys with the client, the client must use state.ProjectID()
+func NewStore(client *firestore.Client, opts ...Option) *Store {
+	s := &Store{
+		client:           client,
+		lastUsedInterval: DefaultLastUsedInterval,
+		now:              time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Create stores a new key for the user scoped to the qualified workspaces, it expires after the ttl unless 0.
+// The key is returned alongside its record and cannot be retrieved again.
+func (s *Store) Create(ctx context.Context, uid, name string, workspaces []string, ttl time.Duration) (string, *Key, error) {
+	key, record, err := newKey(uid, name, workspaces, ttl, s.now())
+	if err != nil {
+		return "", nil, err
+	}
+	err = s.client.RunTransaction(ctx, func(ctx context.Context, txn *firestore.Transaction) error {
+		_, err := state.Serialize(txn, state.CollectionAPIKey, record, nil, nil)
+		return err
+	})
+	if err != nil {
+		return "", nil, status.Errorf(codes.Internal, "unable to store api key: %v", err)
+	}
+	return key, record, nil
+}
+
+// Get returns the record of the key id
+func (s *Store) Get(ctx context.Context, id string) (*Key, error) {
+	snap, err := s.client.Collection(string(state.CollectionAPIKey)).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, status.Errorf(codes.NotFound, "api key %s not found", id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to get api key %s: %v", id, err)
+	}
+	record := &Key{}
+	if err := state.DeserializeSnapshot(snap, record); err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to read api key %s: %v", id, err)
+	}
+	return record, nil
+}
+
+// List returns the records of the keys belonging to the user
+func (s *Store) List(ctx context.Context, uid string) ([]*Key, error) {
+	snaps, err := s.client.Collection(string(state.CollectionAPIKey)).Where("Proto.Uid", "==", uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to list api keys: %v", err)
+	}
+	records := make([]*Key, 0, len(snaps))
+	for _, snap := range snaps {
+		record := &Key{}
+		if err := state.DeserializeSnapshot(snap, record); err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to read api key %s: %v", snap.Ref.ID, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Revoke removes the key id belonging to the user, keys of other users are reported as not found
+func (s *Store) Revoke(ctx context.Context, uid, id string) error {
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, txn *firestore.Transaction) error {
+		snap, err := txn.Get(s.client.Collection(string(state.CollectionAPIKey)).Doc(id))
+		if status.Code(err) == codes.NotFound {
+			return status.Errorf(codes.NotFound, "api key %s not found", id)
+		}
+		if err != nil {
+			return err
+		}
+		record := &Key{}
+		if err := state.DeserializeSnapshot(snap, record); err != nil {
+			return err
+		}
+		if record.GetUid() != uid {
+			return status.Errorf(codes.NotFound, "api key %s not found", id)
+		}
+		return state.RemoveSerialized(*s.client, txn, state.CollectionAPIKey, record)
+	})
+	if _, ok := status.FromError(err); !ok {
+		return status.Errorf(codes.Internal, "unable to revoke api key %s: %v", id, err)
+	}
+	return err
+}
+
+// Verify returns the record of a valid key and records its use
+func (s *Store) Verify(ctx context.Context, key string) (*Key, error) {
+	id, secret, err := Parse(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.verify(ctx, id, secret)
+}
+
+func (s *Store) verify(ctx context.Context, id, secret string) (*Key, error) {
+	record, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	if err := record.check(secret, now); err != nil {
+		return nil, err
+	}
+	if record.stale(now, s.lastUsedInterval) {
+		// Failing to record the use of a key does not fail the request
+		if err := s.touch(ctx, id, now); err != nil {
+			klog.Warningf("unable to record use of api key %s: %v", id, err)
+		}
+		record.LastUsedTime = timestamppb.New(now)
+	}
+	return record, nil
+}
+
+// touch writes the last used time of the key, unless it has been revoked or written since
+func (s *Store) touch(ctx context.Context, id string, now time.Time) error {
+	return s.client.RunTransaction(ctx, func(ctx context.Context, txn *firestore.Transaction) error {
+		snap, err := txn.Get(s.client.Collection(string(state.CollectionAPIKey)).Doc(id))
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		record := &Key{}
+		if err := state.DeserializeSnapshot(snap, record); err != nil {
+			return err
+		}
+		if !record.stale(now, s.lastUsedInterval) {
+			return nil
+		}
+		record.LastUsedTime = timestamppb.New(now)
+		_, err = state.Serialize(txn, state.CollectionAPIKey, record, nil, nil)
+		return err
+	})
+}
+
+// VerifyIDToken verifies the Basic authorization credentials of a key, returning a token for the user the key belongs
+// to. Scoped keys carry their workspaces in the workspaces claim, a key scoped to a single workspace defaults to it.
+func (s *Store) VerifyIDToken(ctx context.Context, credentials string) (*fbauth.Token, error) {
+	id, secret, err := parseBasic(credentials)
+	if err != nil {
+		return nil, err
+	}
+	record, err := s.verify(ctx, id, secret)
+	if err != nil {
+		return nil, err
+	}
+	return record.token(), nil
+}
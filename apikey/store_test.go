@@ -0,0 +1,103 @@
+package apikey
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apimeta "github.com/drud/api-common/metadata"
+	"github.com/drud/api-common/state"
+)
+
+// testStore returns a store backed by the Firestore emulator, the tests are skipped without one
+func testStore(t *testing.T) *Store {
+	t.Helper()
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	client, err := firestore.NewClient(context.Background(), state.ProjectID())
+	if err != nil {
+		t.Fatalf("firestore.NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return NewStore(client)
+}
+
+func TestStore(t *testing.T) {
+	store := testStore(t)
+	clock := time.Now().Truncate(time.Second)
+	store.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	key, record, err := store.Create(ctx, "alice", "ci", []string{"acme.prod"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	scoped, err := store.VerifyIDToken(ctx, BasicCredentials(key))
+	if err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+	if scoped.UID != "alice" || scoped.Claims[apimeta.ClaimKeyAPIKey] != record.Id ||
+		scoped.Claims[apimeta.ClaimKeyDefaultWorkspace] != "acme.prod" ||
+		!reflect.DeepEqual(scoped.Claims[apimeta.ClaimKeyWorkspaces], []interface{}{"acme.prod"}) {
+		t.Errorf("VerifyIDToken() = %+v", scoped)
+	}
+	if _, err := store.Verify(ctx, Format(record.Id, "wrong")); err == nil {
+		t.Errorf("Verify() accepted the wrong secret")
+	}
+
+	// Use is recorded at most once per interval
+	stored, err := store.Get(ctx, record.Id)
+	if err != nil || !stored.GetLastUsedTime().AsTime().Equal(clock) {
+		t.Errorf("Get() last used = %v, %v, want %v", stored.GetLastUsedTime(), err, clock)
+	}
+	clock = clock.Add(time.Minute)
+	if _, err := store.Verify(ctx, key); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if stored, _ := store.Get(ctx, record.Id); !stored.GetLastUsedTime().AsTime().Equal(clock.Add(-time.Minute)) {
+		t.Errorf("Get() last used = %v within the interval", stored.GetLastUsedTime())
+	}
+
+	// Keys are listed for their owner and revoked only by them
+	other, _, err := store.Create(ctx, "alice", "deploy", nil, 0)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if keys, err := store.List(ctx, "alice"); err != nil || len(keys) < 2 {
+		t.Errorf("List() = %d keys, %v", len(keys), err)
+	}
+	if err := store.Revoke(ctx, "bob", record.Id); status.Code(err) != codes.NotFound {
+		t.Errorf("Revoke() by another user error = %v, want NotFound", err)
+	}
+	if err := store.Revoke(ctx, "alice", record.Id); err != nil {
+		t.Errorf("Revoke() error = %v", err)
+	}
+	if _, err := store.Verify(ctx, key); status.Code(err) != codes.NotFound {
+		t.Errorf("Verify() revoked key error = %v, want NotFound", err)
+	}
+
+	// Expired keys are rejected, keys without a ttl do not expire
+	clock = clock.Add(2 * time.Hour)
+	unscoped, err := store.VerifyIDToken(ctx, BasicCredentials(other))
+	if err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+	if _, ok := unscoped.Claims[apimeta.ClaimKeyWorkspaces]; ok {
+		t.Errorf("VerifyIDToken() unscoped key claims = %v", unscoped.Claims)
+	}
+	expiring, _, err := store.Create(ctx, "alice", "expiring", nil, time.Minute)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	clock = clock.Add(time.Minute)
+	if _, err := store.Verify(ctx, expiring); err == nil {
+		t.Errorf("Verify() accepted an expired key")
+	}
+}
@@ -0,0 +1,137 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	apictx "github.com/drud/api-common/context"
+	apierr "github.com/drud/api-common/errors"
+)
+
+// SubscriptionMembership determines whether a user belongs to a subscription
+type SubscriptionMembership interface {
+	IsSubscriptionMember(ctx context.Context, uid, subscription string) (bool, error)
+}
+
+// Option configures an Authorizer
+type Option func(*Authorizer)
+
+// WithSubscriptionMembership sets the membership source used by rules requiring subscription membership
+func WithSubscriptionMembership(membership SubscriptionMembership) Option {
+	return func(a *Authorizer) {
+		a.membership = membership
+	}
+}
+
+// Authorizer evaluates a policy against the request state set by the state interceptors.
+// Its interceptors must be chained after the state interceptors.
+type Authorizer struct {
+	policy     *Policy
+	membership SubscriptionMembership
+}
+
+// NewAuthorizer creates an Authorizer enforcing the supplied policy
+func NewAuthorizer(policy *Policy, opts ...Option) (*Authorizer, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	a := &Authorizer{
+		policy: policy,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if policy.requiresMembership() && a.membership == nil {
+		return nil, fmt.Errorf("policy requires subscription membership however no membership source was supplied")
+	}
+	return a, nil
+}
+
+// Authorize evaluates the rule for the procedure stored in the context
+func (a *Authorizer) Authorize(ctx context.Context) error {
+	procedure, err := apictx.ProcedureFromContext(ctx)
+	if err != nil {
+		return apierr.PermissionDenied.New(ctx, err)
+	}
+	rule := a.policy.RuleFor(procedure)
+	if rule == nil {
+		return apierr.PermissionDenied.New(ctx, fmt.Errorf("no policy rule for %s", procedure))
+	}
+	if rule.Public {
+		return nil
+	}
+
+	user, userErr := apictx.UserFromContext(ctx)
+	if (rule.RequireUser || rule.RequireSubscriptionMember || len(rule.Claims) > 0) && userErr != nil {
+		return apierr.AuthenticationRequired.New(ctx, userErr)
+	}
+	if rule.RequireWorkspace || rule.RequireSubscriptionMember {
+		if _, err := apictx.NamespaceFromContext(ctx); err != nil {
+			return apierr.WorkspaceRequired.New(ctx, err)
+		}
+	}
+	if len(rule.Claims) > 0 {
+		token, err := apictx.AuthTokenFromContext(ctx)
+		if err != nil {
+			return apierr.AuthenticationRequired.New(ctx, err)
+		}
+		for claim, want := range rule.Claims {
+			if !claimMatches(token.Claims[claim], want) {
+				return apierr.PermissionDenied.New(ctx, fmt.Errorf("claim %s does not match %v", claim, want))
+			}
+		}
+	}
+	if rule.RequireSubscriptionMember {
+		subscription, err := apictx.SubscriptionFromContext(ctx)
+		if err != nil {
+			return apierr.PermissionDenied.New(ctx, err)
+		}
+		member, err := a.membership.IsSubscriptionMember(ctx, user, subscription)
+		if err != nil {
+			return apierr.AbstractError(ctx, codes.Internal, "an internal error occured verifying subscription membership", err)
+		}
+		if !member {
+			return apierr.PermissionDenied.New(ctx, fmt.Errorf("user is not a member of subscription %s", subscription))
+		}
+	}
+	return nil
+}
+
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.Authorize(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *Authorizer) StreamingServerInterceptor() grpc.StreamServerInterceptor {
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.Authorize(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// claimMatches compares a token claim with the policy value, list claims match if they contain the value
+func claimMatches(claim interface{}, want interface{}) bool {
+	if reflect.DeepEqual(claim, want) {
+		return true
+	}
+	if list, ok := claim.([]interface{}); ok {
+		for _, elem := range list {
+			if reflect.DeepEqual(elem, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
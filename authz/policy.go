@@ -0,0 +1,107 @@
+package authz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Rule describes the conditions a request must satisfy to invoke the methods it matches
+type Rule struct {
+	// Methods are gRPC full method names, e.g. /live.billing.v1alpha1.Billing/GetPlan, or globs such as /live.billing.v1alpha1.Billing/*
+	Methods []string `json:"methods,omitempty"`
+	// Public allows the methods without any further conditions
+	Public bool `json:"public,omitempty"`
+	// RequireUser requires an authenticated user
+	RequireUser bool `json:"requireUser,omitempty"`
+	// RequireWorkspace requires the request to be scoped to a resolved workspace namespace
+	RequireWorkspace bool `json:"requireWorkspace,omitempty"`
+	// RequireSubscriptionMember requires the user to be a member of the subscription owning the workspace
+	RequireSubscriptionMember bool `json:"requireSubscriptionMember,omitempty"`
+	// Claims are custom token claims which must be present with the given values
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Policy maps gRPC methods to the rules guarding them, the first matching rule applies
+type Policy struct {
+	Rules []Rule `json:"rules"`
+	// Default applies to methods not matched by any rule, when unset such methods are denied
+	Default *Rule `json:"default,omitempty"`
+}
+
+// LoadPolicyFile reads a YAML or JSON policy from disk
+func LoadPolicyFile(filename string) (*Policy, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses a YAML or JSON policy document
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, fmt.Errorf("error parsing policy: %v", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate checks the method patterns of every rule are well formed and that every rule sets a condition, public
+// methods must be marked as such rather than left without conditions
+func (p *Policy) Validate() error {
+	if p.Default != nil && !p.Default.hasConditions() {
+		return fmt.Errorf("default rule has no conditions")
+	}
+	for i, rule := range p.Rules {
+		if len(rule.Methods) == 0 {
+			return fmt.Errorf("rule %d matches no methods", i)
+		}
+		if !rule.hasConditions() {
+			return fmt.Errorf("rule %d has no conditions", i)
+		}
+		for _, method := range rule.Methods {
+			if _, err := path.Match(method, ""); err != nil {
+				return fmt.Errorf("rule %d has invalid method pattern %q: %v", i, method, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RuleFor returns the rule governing a full method name, or nil if the method is not covered by the policy
+func (p *Policy) RuleFor(method string) *Rule {
+	for i := range p.Rules {
+		for _, pattern := range p.Rules[i].Methods {
+			if pattern == method {
+				return &p.Rules[i]
+			}
+			if matched, err := path.Match(pattern, method); err == nil && matched {
+				return &p.Rules[i]
+			}
+		}
+	}
+	return p.Default
+}
+
+// hasConditions reports whether the rule is public or requires anything of the request
+func (r *Rule) hasConditions() bool {
+	return r.Public || r.RequireUser || r.RequireWorkspace || r.RequireSubscriptionMember || len(r.Claims) > 0
+}
+
+func (p *Policy) requiresMembership() bool {
+	if p.Default != nil && p.Default.RequireSubscriptionMember {
+		return true
+	}
+	for _, rule := range p.Rules {
+		if rule.RequireSubscriptionMember {
+			return true
+		}
+	}
+	return false
+}
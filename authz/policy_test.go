@@ -0,0 +1,147 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	fbauth "firebase.google.com/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/klog/klogr"
+
+	apictx "github.com/drud/api-common/context"
+	apierr "github.com/drud/api-common/errors"
+)
+
+const testPolicy = `
+rules:
+- methods: ["/grpc.health.v1.Health/*"]
+  public: true
+- methods: ["/test.Admin/*"]
+  requireUser: true
+  claims:
+    admin: true
+- methods: ["/test.Sites/*"]
+  requireWorkspace: true
+  requireSubscriptionMember: true
+default:
+  requireUser: true
+`
+
+type staticMembership map[string][]string
+
+func (s staticMembership) IsSubscriptionMember(ctx context.Context, uid, subscription string) (bool, error) {
+	for _, member := range s[subscription] {
+		if member == uid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestAuthorize(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("ParsePolicy() error = %v", err)
+	}
+	authorizer, err := NewAuthorizer(policy, WithSubscriptionMembership(staticMembership{"acme": {"alice"}}))
+	if err != nil {
+		t.Fatalf("NewAuthorizer() error = %v", err)
+	}
+
+	requestContext := func(procedure, user string, claims map[string]interface{}, subscription, namespace string) context.Context {
+		ctx := context.WithValue(context.Background(), apictx.ContextKeyProcedure{}, procedure)
+		if user != "" {
+			ctx = context.WithValue(ctx, apictx.ContextKeyUser{}, user)
+			ctx = context.WithValue(ctx, apictx.ContextKeyToken{}, &fbauth.Token{UID: user, Claims: claims})
+		}
+		if subscription != "" {
+			ctx = context.WithValue(ctx, apictx.ContextKeySubscription{}, subscription)
+		}
+		if namespace != "" {
+			ctx = context.WithValue(ctx, apictx.ContextKeyNamespace{}, namespace)
+		}
+		return ctx
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		{name: "public", ctx: requestContext("/grpc.health.v1.Health/Check", "", nil, "", ""), wantCode: codes.OK},
+		{name: "default authenticated", ctx: requestContext("/test.Other/Get", "alice", nil, "", ""), wantCode: codes.OK},
+		{name: "default anonymous", ctx: requestContext("/test.Other/Get", "", nil, "", ""), wantCode: codes.Unauthenticated},
+		{name: "admin claim", ctx: requestContext("/test.Admin/Delete", "alice", map[string]interface{}{"admin": true}, "", ""), wantCode: codes.OK},
+		{name: "missing admin claim", ctx: requestContext("/test.Admin/Delete", "alice", map[string]interface{}{}, "", ""), wantCode: codes.PermissionDenied},
+		{name: "subscription member", ctx: requestContext("/test.Sites/List", "alice", nil, "acme", "ns-acme-prod"), wantCode: codes.OK},
+		{name: "not a subscription member", ctx: requestContext("/test.Sites/List", "bob", nil, "acme", "ns-acme-prod"), wantCode: codes.PermissionDenied},
+		{name: "no workspace", ctx: requestContext("/test.Sites/List", "alice", nil, "", ""), wantCode: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizer.Authorize(tt.ctx)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Authorize() code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeLogsDenial(t *testing.T) {
+	var buf bytes.Buffer
+	apierr.SetLogger(apierr.NewJSONLogger(&buf))
+	defer apierr.SetLogger(klogr.New())
+
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("ParsePolicy() error = %v", err)
+	}
+	authorizer, err := NewAuthorizer(policy, WithSubscriptionMembership(staticMembership{"acme": {"alice"}}))
+	if err != nil {
+		t.Fatalf("NewAuthorizer() error = %v", err)
+	}
+	ctx := context.WithValue(context.Background(), apictx.ContextKeyProcedure{}, "/test.Sites/List")
+	ctx = context.WithValue(ctx, apictx.ContextKeyUser{}, "alice")
+
+	if err := authorizer.Authorize(ctx); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Authorize() = %v, want PermissionDenied", err)
+	}
+	if !strings.Contains(buf.String(), `"level":"error"`) || !strings.Contains(buf.String(), "a workspace is required for request") {
+		t.Errorf("Authorize() logged %q, want the workspace denial", buf.String())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{name: "valid", policy: testPolicy},
+		{name: "no methods", policy: "rules:\n- public: true\n", wantErr: true},
+		{name: "invalid pattern", policy: "rules:\n- methods: [\"/test.Sites/[\"]\n  public: true\n", wantErr: true},
+		{name: "no conditions", policy: "rules:\n- methods: [\"/test.Sites/*\"]\n", wantErr: true},
+		{name: "default without conditions", policy: "rules: []\ndefault: {}\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePolicy([]byte(tt.policy)); (err != nil) != tt.wantErr {
+				t.Errorf("ParsePolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewAuthorizerRequiresMembership(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("ParsePolicy() error = %v", err)
+	}
+	if _, err := NewAuthorizer(policy); err == nil {
+		t.Errorf("NewAuthorizer() expected error without a membership source")
+	}
+}
@@ -0,0 +1,95 @@
+package context
+
+import (
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// Authorization schemes, compared case-insensitively and stored in lower case
+const (
+	SchemeBearer = "bearer"
+	SchemeBasic  = "basic"
+)
+
+// ErrNoCredentials is returned when the request carries neither the x-auth-token nor the authorization metadata
+var ErrNoCredentials = status.Error(codes.InvalidArgument, "no auth details supplied")
+
+// Credentials are the credentials a request was made with
+type Credentials struct {
+	// Scheme is the lower case authorization scheme, x-auth-token values are bearer tokens
+	Scheme string
+	Token  string
+	// Legacy is set for authorization values without a scheme, which are treated as bearer tokens
+	Legacy bool
+}
+
+// CredentialsFromMeta returns the credentials of the x-auth-token metadata, or else of the authorization metadata.
+// Empty or multiple credentials are rejected.
+func CredentialsFromMeta(meta metadata.MD) (*Credentials, error) {
+	if elem, ok := meta[apimeta.HeaderAuthToken]; ok {
+		if len(elem) > 1 {
+			return nil, status.Errorf(codes.InvalidArgument, "multiple auth tokens supplied")
+		}
+		if len(elem) == 0 || strings.TrimSpace(elem[0]) == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "empty auth token supplied")
+		}
+		return &Credentials{Scheme: SchemeBearer, Token: strings.TrimSpace(elem[0])}, nil
+	}
+	if elem, ok := meta["authorization"]; ok {
+		if len(elem) > 1 {
+			return nil, status.Errorf(codes.InvalidArgument, "multiple authorization credentials supplied")
+		}
+		if len(elem) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "empty authorization supplied")
+		}
+		return ParseAuthorization(elem[0])
+	}
+	return nil, ErrNoCredentials
+}
+
+// ParseAuthorization parses an RFC 7235 authorization value, e.g. "Bearer <token>". A value without a scheme is
+// returned as legacy bearer credentials.
+func ParseAuthorization(authorization string) (*Credentials, error) {
+	fields := strings.Fields(authorization)
+	switch len(fields) {
+	case 0:
+		return nil, status.Errorf(codes.InvalidArgument, "empty authorization supplied")
+	case 1:
+		if scheme := strings.ToLower(fields[0]); scheme == SchemeBearer || scheme == SchemeBasic {
+			return nil, status.Errorf(codes.InvalidArgument, "no %s credentials supplied", scheme)
+		}
+		if !isToken68(fields[0]) {
+			return nil, status.Errorf(codes.InvalidArgument, "malformed authorization supplied")
+		}
+		return &Credentials{Scheme: SchemeBearer, Token: fields[0], Legacy: true}, nil
+	case 2:
+		if !isToken68(fields[1]) {
+			return nil, status.Errorf(codes.InvalidArgument, "malformed %s credentials supplied", strings.ToLower(fields[0]))
+		}
+		return &Credentials{Scheme: strings.ToLower(fields[0]), Token: fields[1]}, nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "multiple authorization credentials supplied")
+	}
+}
+
+// isToken68 reports whether the value matches the token68 syntax of RFC 7235, the b64token syntax of RFC 6750
+func isToken68(value string) bool {
+	trimmed := strings.TrimRight(value, "=")
+	if trimmed == "" {
+		return false
+	}
+	for _, r := range trimmed {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '.', r == '_', r == '~', r == '+', r == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
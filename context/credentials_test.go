@@ -0,0 +1,125 @@
+package context
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func TestCredentialsFromMeta(t *testing.T) {
+	tests := []struct {
+		name     string
+		md       metadata.MD
+		want     *Credentials
+		wantCode codes.Code
+	}{
+		{
+			name: "auth token",
+			md:   metadata.Pairs(apimeta.HeaderAuthToken, " token-alice "),
+			want: &Credentials{Scheme: SchemeBearer, Token: "token-alice"},
+		},
+		{
+			name: "auth token preferred",
+			md:   metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", "authorization", "Bearer token-bob"),
+			want: &Credentials{Scheme: SchemeBearer, Token: "token-alice"},
+		},
+		{
+			name: "bearer",
+			md:   metadata.Pairs("authorization", "Bearer token-alice"),
+			want: &Credentials{Scheme: SchemeBearer, Token: "token-alice"},
+		},
+		{
+			name: "scheme case insensitive",
+			md:   metadata.Pairs("authorization", "bEaReR  token-alice"),
+			want: &Credentials{Scheme: SchemeBearer, Token: "token-alice"},
+		},
+		{
+			name: "basic",
+			md:   metadata.Pairs("authorization", "Basic a2V5OnNlY3JldA=="),
+			want: &Credentials{Scheme: SchemeBasic, Token: "a2V5OnNlY3JldA=="},
+		},
+		{
+			name: "legacy",
+			md:   metadata.Pairs("authorization", "token-alice"),
+			want: &Credentials{Scheme: SchemeBearer, Token: "token-alice", Legacy: true},
+		},
+		{
+			name:     "none",
+			md:       metadata.Pairs(apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "empty auth token",
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, " "),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "multiple auth tokens",
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderAuthToken, "token-bob"),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "empty authorization",
+			md:       metadata.Pairs("authorization", ""),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "scheme without credentials",
+			md:       metadata.Pairs("authorization", "Bearer "),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "multiple authorization headers",
+			md:       metadata.Pairs("authorization", "Bearer token-alice", "authorization", "Bearer token-bob"),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "multiple credentials",
+			md:       metadata.Pairs("authorization", "Bearer token-alice token-bob"),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "malformed token",
+			md:       metadata.Pairs("authorization", "Bearer token,alice"),
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CredentialsFromMeta(tt.md)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("CredentialsFromMeta() error = %v, want %v", err, tt.wantCode)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CredentialsFromMeta() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthTokenFromMeta(t *testing.T) {
+	tests := []struct {
+		name     string
+		md       metadata.MD
+		want     string
+		wantCode codes.Code
+	}{
+		{name: "auth token", md: metadata.Pairs(apimeta.HeaderAuthToken, "token-alice"), want: "token-alice"},
+		{name: "bearer", md: metadata.Pairs("authorization", "Bearer token-alice"), want: "token-alice"},
+		{name: "basic", md: metadata.Pairs("authorization", "Basic dXNlcjpwYXNz"), wantCode: codes.InvalidArgument},
+		{name: "no scheme", md: metadata.Pairs("authorization", "token-alice"), wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AuthTokenFromMeta(tt.md)
+			if code := status.Code(err); code != tt.wantCode || got != tt.want {
+				t.Errorf("AuthTokenFromMeta() = %q, %v, want %q, %v", got, err, tt.want, tt.wantCode)
+			}
+		})
+	}
+}
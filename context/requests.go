@@ -3,6 +3,7 @@ package context
 import (
 	"context"
 	"strings"
+	"sync"
 
 	fbauth "firebase.google.com/go/auth"
 	"google.golang.org/grpc/codes"
@@ -12,6 +13,8 @@ import (
 	apimeta "github.com/drud/api-common/metadata"
 )
 
+// ContextKey types identify the fields of the RequestState. Values stored directly under
+// these keys are still honoured by the getters, however the interceptors store a RequestState.
 type ContextKeyWorkspace struct{}
 type ContextKeyQualifiedWorkspace struct{}
 type ContextKeyNamespace struct{}
@@ -20,6 +23,32 @@ type ContextKeyUser struct{}
 type ContextKeyToken struct{}
 type ContextKeyUserRecord struct{}
 type ContextKeyProcedure struct{}
+type ContextKeyBearer struct{}
+type ContextKeyService struct{}
+type ContextKeyActor struct{}
+type ContextKeyRequestID struct{}
+
+// Lazy defers computing a RequestState field, set with SetLazy or stored under a ContextKey type, until it is first requested.
+// The result is memoized and Lazy is safe for concurrent use. When computing the value fails the
+// getters behave as if the value was never set.
+type Lazy struct {
+	once    sync.Once
+	resolve func() (interface{}, error)
+	value   interface{}
+	err     error
+}
+
+func NewLazy(resolve func() (interface{}, error)) *Lazy {
+	return &Lazy{resolve: resolve}
+}
+
+// Value computes the value on the first call and returns the same result thereafter
+func (l *Lazy) Value() (interface{}, error) {
+	l.once.Do(func() {
+		l.value, l.err = l.resolve()
+	})
+	return l.value, l.err
+}
 
 func WorkspaceFromMeta(meta metadata.MD) (string, error) {
 	getElement := func(elem []string) (string, error) {
@@ -36,102 +65,70 @@ func WorkspaceFromMeta(meta metadata.MD) (string, error) {
 	return "", status.Errorf(codes.InvalidArgument, "no workspace details supplied")
 }
 
+// AuthTokenFromMeta returns the bearer token of the request. Authorization values without a scheme are rejected,
+// servers accepting them with interceptors.WithLegacyAuthorization must use CredentialsFromMeta to tell them apart.
 func AuthTokenFromMeta(meta metadata.MD) (string, error) {
-	getElement := func(elem []string) (string, error) {
-		if len(elem) == 0 {
-			return "", status.Errorf(codes.InvalidArgument, "no auth details supplied")
-		}
-		return strings.TrimSpace(elem[0]), nil
+	creds, err := CredentialsFromMeta(meta)
+	if err != nil {
+		return "", err
 	}
-
-	if elem, ok := meta[apimeta.HeaderAuthToken]; ok {
-		return getElement(elem)
+	if creds.Scheme != SchemeBearer {
+		return "", status.Errorf(codes.InvalidArgument, "unsupported authorization scheme %s", creds.Scheme)
 	}
-	// Deprecate
-	if authorization, ok := meta["authorization"]; ok {
-		return getElement(authorization)
+	if creds.Legacy {
+		return "", status.Errorf(codes.InvalidArgument, "authorization supplied without a scheme")
 	}
-
-	return "", status.Errorf(codes.InvalidArgument, "no auth details supplied")
+	return creds.Token, nil
 }
 
 func NamespaceFromContext(ctx context.Context) (string, error) {
-	iface := ctx.Value(ContextKeyNamespace{})
-	if iface != nil {
-		if ws, ok := iface.(string); ok {
-			return ws, nil
-		}
-	}
-	// message for the user
-	return "", status.Error(codes.NotFound, "unable to determine workspace for request")
+	return stateFrom(ctx).RequireNamespace()
 }
 
 func WorkspaceFromContext(ctx context.Context) (string, error) {
-	iface := ctx.Value(ContextKeyWorkspace{})
-	if iface != nil {
-		if ws, ok := iface.(string); ok {
-			return ws, nil
-		}
-	}
-	return "", status.Error(codes.NotFound, "unable to determine workspace for request")
+	return stateFrom(ctx).RequireWorkspace()
 }
 
 func QualifiedWorkspaceFromContext(ctx context.Context) (string, error) {
-	iface := ctx.Value(ContextKeyQualifiedWorkspace{})
-	if iface != nil {
-		if ws, ok := iface.(string); ok {
-			return ws, nil
-		}
-	}
-	return "", status.Error(codes.NotFound, "unable to determine workspace for request")
+	return stateFrom(ctx).RequireQualifiedWorkspace()
 }
 
 func SubscriptionFromContext(ctx context.Context) (string, error) {
-	iface := ctx.Value(ContextKeySubscription{})
-	if iface != nil {
-		if sub, ok := iface.(string); ok {
-			return sub, nil
-		}
-	}
-	return "", status.Error(codes.NotFound, "unable to determine subscription for request")
+	return stateFrom(ctx).RequireSubscription()
 }
 
 func UserFromContext(ctx context.Context) (string, error) {
-	iface := ctx.Value(ContextKeyUser{})
-	if iface != nil {
-		if user, ok := iface.(string); ok {
-			return user, nil
-		}
-	}
-	return "", status.Error(codes.NotFound, "unable to determine user for request")
+	return stateFrom(ctx).RequireUser()
 }
 
 func ProcedureFromContext(ctx context.Context) (string, error) {
-	iface := ctx.Value(ContextKeyProcedure{})
-	if iface != nil {
-		if procedure, ok := iface.(string); ok {
-			return procedure, nil
-		}
-	}
-	return "", status.Error(codes.NotFound, "unable to determine procedure for request")
+	return stateFrom(ctx).RequireProcedure()
+}
+
+// BearerFromContext returns the verified raw token the request was authenticated with, e.g. for forwarding to other services
+func BearerFromContext(ctx context.Context) (string, error) {
+	return stateFrom(ctx).RequireBearer()
+}
+
+// ServiceFromContext returns the identity of the trusted service which made the request
+func ServiceFromContext(ctx context.Context) (string, error) {
+	return stateFrom(ctx).RequireService()
+}
+
+// ActorFromContext returns the UID of the user impersonating the request user
+func ActorFromContext(ctx context.Context) (string, error) {
+	return stateFrom(ctx).RequireActor()
+}
+
+// RequestIDFromContext returns the ID correlating the logs of the request across services
+func RequestIDFromContext(ctx context.Context) (string, error) {
+	return stateFrom(ctx).RequireRequestID()
 }
 
 func AuthTokenFromContext(ctx context.Context) (*fbauth.Token, error) {
-	iface := ctx.Value(ContextKeyToken{})
-	if iface != nil {
-		if token, ok := iface.(*fbauth.Token); ok {
-			return token, nil
-		}
-	}
-	return nil, status.Error(codes.NotFound, "unable to determine token for request")
+	return stateFrom(ctx).RequireToken()
 }
 
 func UserRecordFromContext(ctx context.Context) (*fbauth.UserRecord, error) {
-	iface := ctx.Value(ContextKeyUserRecord{})
-	if iface != nil {
-		if record, ok := iface.(*fbauth.UserRecord); ok {
-			return record, nil
-		}
-	}
-	return nil, status.Error(codes.NotFound, "unable to determine user for request")
+	return stateFrom(ctx).RequireUserRecord()
 }
@@ -0,0 +1,240 @@
+package context
+
+import (
+	"context"
+
+	fbauth "firebase.google.com/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type contextKeyState struct{}
+
+// RequestState is the state derived for a request by the interceptors
+type RequestState struct {
+	Procedure  string
+	RequestID  string
+	User       string
+	Bearer     string
+	Token      *fbauth.Token
+	UserRecord *fbauth.UserRecord
+	// Service identifies a trusted service principal, which may act on behalf of User
+	Service string
+	// Actor is the real user when User is being impersonated, Token then belongs to the actor
+	Actor              string
+	Workspace          string
+	QualifiedWorkspace string
+	Subscription       string
+	Namespace          string
+
+	// lazy holds values computed on first access keyed by the ContextKey type of the field
+	lazy map[interface{}]*Lazy
+}
+
+var stateKeys = []interface{}{
+	ContextKeyProcedure{},
+	ContextKeyRequestID{},
+	ContextKeyUser{},
+	ContextKeyBearer{},
+	ContextKeyToken{},
+	ContextKeyUserRecord{},
+	ContextKeyService{},
+	ContextKeyActor{},
+	ContextKeyWorkspace{},
+	ContextKeyQualifiedWorkspace{},
+	ContextKeySubscription{},
+	ContextKeyNamespace{},
+}
+
+// FromContext returns a copy of the request state stored in the context. Contexts without a stored state, e.g. those
+// built outside the interceptors, fall back to the values stored under the individual ContextKey types.
+func FromContext(ctx context.Context) *RequestState {
+	stored, ok := ctx.Value(contextKeyState{}).(*RequestState)
+	if !ok {
+		return fromKeys(ctx)
+	}
+	state := *stored
+	state.lazy = make(map[interface{}]*Lazy, len(stored.lazy))
+	for k, v := range stored.lazy {
+		state.lazy[k] = v
+	}
+	return &state
+}
+
+// stateFrom returns the request state stored in the context without copying it, for the getters which only read it
+func stateFrom(ctx context.Context) *RequestState {
+	if stored, ok := ctx.Value(contextKeyState{}).(*RequestState); ok {
+		return stored
+	}
+	return fromKeys(ctx)
+}
+
+// fromKeys returns the request state of the values stored under the individual ContextKey types
+func fromKeys(ctx context.Context) *RequestState {
+	state := &RequestState{}
+	for _, key := range stateKeys {
+		iface := ctx.Value(key)
+		if iface == nil {
+			continue
+		}
+		if lazy, ok := iface.(*Lazy); ok {
+			state.set(key, nil)
+			state.SetLazy(key, lazy)
+			continue
+		}
+		state.set(key, iface)
+	}
+	return state
+}
+
+// WithState returns a context carrying the request state
+func WithState(ctx context.Context, state *RequestState) context.Context {
+	return context.WithValue(ctx, contextKeyState{}, state)
+}
+
+// SetLazy defers computing the field identified by its ContextKey type, e.g. ContextKeyNamespace{}, until it is required
+func (s *RequestState) SetLazy(key interface{}, lazy *Lazy) {
+	if s.lazy == nil {
+		s.lazy = make(map[interface{}]*Lazy)
+	}
+	s.lazy[key] = lazy
+}
+
+func (s *RequestState) set(key interface{}, iface interface{}) {
+	str, _ := iface.(string)
+	switch key.(type) {
+	case ContextKeyProcedure:
+		s.Procedure = str
+	case ContextKeyRequestID:
+		s.RequestID = str
+	case ContextKeyUser:
+		s.User = str
+	case ContextKeyBearer:
+		s.Bearer = str
+	case ContextKeyToken:
+		s.Token, _ = iface.(*fbauth.Token)
+	case ContextKeyUserRecord:
+		s.UserRecord, _ = iface.(*fbauth.UserRecord)
+	case ContextKeyService:
+		s.Service = str
+	case ContextKeyActor:
+		s.Actor = str
+	case ContextKeyWorkspace:
+		s.Workspace = str
+	case ContextKeyQualifiedWorkspace:
+		s.QualifiedWorkspace = str
+	case ContextKeySubscription:
+		s.Subscription = str
+	case ContextKeyNamespace:
+		s.Namespace = str
+	}
+	delete(s.lazy, key)
+}
+
+func (s *RequestState) resolve(key interface{}) interface{} {
+	if lazy, ok := s.lazy[key]; ok {
+		if value, err := lazy.Value(); err == nil {
+			return value
+		}
+	}
+	return nil
+}
+
+func (s *RequestState) requireString(value string, key interface{}) (string, bool) {
+	if value != "" {
+		return value, true
+	}
+	str, ok := s.resolve(key).(string)
+	return str, ok && str != ""
+}
+
+func (s *RequestState) RequireProcedure() (string, error) {
+	if procedure, ok := s.requireString(s.Procedure, ContextKeyProcedure{}); ok {
+		return procedure, nil
+	}
+	return "", status.Error(codes.NotFound, "unable to determine procedure for request")
+}
+
+func (s *RequestState) RequireRequestID() (string, error) {
+	if id, ok := s.requireString(s.RequestID, ContextKeyRequestID{}); ok {
+		return id, nil
+	}
+	return "", status.Error(codes.NotFound, "unable to determine request id for request")
+}
+
+func (s *RequestState) RequireUser() (string, error) {
+	if user, ok := s.requireString(s.User, ContextKeyUser{}); ok {
+		return user, nil
+	}
+	return "", status.Error(codes.NotFound, "unable to determine user for request")
+}
+
+func (s *RequestState) RequireBearer() (string, error) {
+	if bearer, ok := s.requireString(s.Bearer, ContextKeyBearer{}); ok {
+		return bearer, nil
+	}
+	return "", status.Error(codes.NotFound, "unable to determine token for request")
+}
+
+func (s *RequestState) RequireToken() (*fbauth.Token, error) {
+	if s.Token != nil {
+		return s.Token, nil
+	}
+	if token, ok := s.resolve(ContextKeyToken{}).(*fbauth.Token); ok && token != nil {
+		return token, nil
+	}
+	return nil, status.Error(codes.NotFound, "unable to determine token for request")
+}
+
+func (s *RequestState) RequireUserRecord() (*fbauth.UserRecord, error) {
+	if s.UserRecord != nil {
+		return s.UserRecord, nil
+	}
+	if record, ok := s.resolve(ContextKeyUserRecord{}).(*fbauth.UserRecord); ok && record != nil {
+		return record, nil
+	}
+	return nil, status.Error(codes.NotFound, "unable to determine user for request")
+}
+
+func (s *RequestState) RequireService() (string, error) {
+	if service, ok := s.requireString(s.Service, ContextKeyService{}); ok {
+		return service, nil
+	}
+	return "", status.Error(codes.NotFound, "unable to determine service for request")
+}
+
+func (s *RequestState) RequireActor() (string, error) {
+	if actor, ok := s.requireString(s.Actor, ContextKeyActor{}); ok {
+		return actor, nil
+	}
+	return "", status.Error(codes.NotFound, "unable to determine actor for request")
+}
+
+func (s *RequestState) RequireWorkspace() (string, error) {
+	if ws, ok := s.requireString(s.Workspace, ContextKeyWorkspace{}); ok {
+		return ws, nil
+	}
+	return "", status.Error(codes.NotFound, "unable to determine workspace for request")
+}
+
+func (s *RequestState) RequireQualifiedWorkspace() (string, error) {
+	if ws, ok := s.requireString(s.QualifiedWorkspace, ContextKeyQualifiedWorkspace{}); ok {
+		return ws, nil
+	}
+	return "", status.Error(codes.NotFound, "unable to determine workspace for request")
+}
+
+func (s *RequestState) RequireSubscription() (string, error) {
+	if sub, ok := s.requireString(s.Subscription, ContextKeySubscription{}); ok {
+		return sub, nil
+	}
+	return "", status.Error(codes.NotFound, "unable to determine subscription for request")
+}
+
+func (s *RequestState) RequireNamespace() (string, error) {
+	if ns, ok := s.requireString(s.Namespace, ContextKeyNamespace{}); ok {
+		return ns, nil
+	}
+	// message for the user
+	return "", status.Error(codes.NotFound, "unable to determine workspace for request")
+}
@@ -0,0 +1,73 @@
+package context
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequestState(t *testing.T) {
+	state := &RequestState{
+		User:         "alice",
+		Subscription: "acme",
+	}
+	state.SetLazy(ContextKeyNamespace{}, NewLazy(func() (interface{}, error) {
+		return "ns-acme-prod", nil
+	}))
+	state.SetLazy(ContextKeyWorkspace{}, NewLazy(func() (interface{}, error) {
+		return nil, fmt.Errorf("resolution failed")
+	}))
+	ctx := WithState(context.Background(), state)
+
+	if user, err := UserFromContext(ctx); err != nil || user != "alice" {
+		t.Errorf("UserFromContext() = %q, %v", user, err)
+	}
+	if ns, err := NamespaceFromContext(ctx); err != nil || ns != "ns-acme-prod" {
+		t.Errorf("NamespaceFromContext() = %q, %v", ns, err)
+	}
+	if _, err := WorkspaceFromContext(ctx); status.Code(err) != codes.NotFound {
+		t.Errorf("WorkspaceFromContext() error = %v, want NotFound", err)
+	}
+	if _, err := FromContext(ctx).RequireProcedure(); status.Code(err) != codes.NotFound {
+		t.Errorf("RequireProcedure() error = %v, want NotFound", err)
+	}
+
+	// The stored state is authoritative over values stored under the individual keys
+	ctx = context.WithValue(ctx, ContextKeySubscription{}, "other")
+	if sub, err := SubscriptionFromContext(ctx); err != nil || sub != "acme" {
+		t.Errorf("SubscriptionFromContext() = %q, %v", sub, err)
+	}
+
+	// Copies returned by FromContext do not modify the stored state
+	copied := FromContext(ctx)
+	copied.User = "bob"
+	copied.SetLazy(ContextKeyNamespace{}, NewLazy(func() (interface{}, error) {
+		return "ns-other", nil
+	}))
+	if user, _ := UserFromContext(ctx); user != "alice" {
+		t.Errorf("UserFromContext() = %q after modifying a copy", user)
+	}
+	if ns, _ := NamespaceFromContext(ctx); ns != "ns-acme-prod" {
+		t.Errorf("NamespaceFromContext() = %q after modifying a copy", ns)
+	}
+}
+
+func TestRequestStateFromKeys(t *testing.T) {
+	// Contexts without a stored state fall back to the individual keys
+	ctx := context.WithValue(context.Background(), ContextKeyUser{}, "alice")
+	ctx = context.WithValue(ctx, ContextKeyNamespace{}, NewLazy(func() (interface{}, error) {
+		return "ns-acme-prod", nil
+	}))
+	if user, err := UserFromContext(ctx); err != nil || user != "alice" {
+		t.Errorf("UserFromContext() = %q, %v", user, err)
+	}
+	if ns, err := NamespaceFromContext(ctx); err != nil || ns != "ns-acme-prod" {
+		t.Errorf("NamespaceFromContext() = %q, %v", ns, err)
+	}
+	if state := FromContext(ctx); state.User != "alice" {
+		t.Errorf("FromContext().User = %q", state.User)
+	}
+}
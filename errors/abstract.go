@@ -1,30 +1,129 @@
 package errors
 
 import (
-	"bytes"
 	"context"
-	"fmt"
+	stderrors "errors"
+	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/klog"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	apictx "github.com/drud/api-common/context"
 )
 
+// Domain is the ErrorInfo domain of the errors returned by the API services
+const Domain = "ddev.live"
+
 // Abstract error will log the error however return the message without the error body
 func AbstractError(ctx context.Context, code codes.Code, message string, err error) error {
-	var buf bytes.Buffer
-	if user, err := apictx.UserFromContext(ctx); err == nil {
-		buf.WriteString(fmt.Sprintf("UID: %s ", user))
+	return AbstractErrorWithDetails(ctx, code, message, err)
+}
+
+// AbstractErrorWithDetails logs the error like AbstractError and attaches the details, e.g. errdetails messages, to the
+// returned status so clients can act on the error by type. RequestInfo carrying the request ID is attached when known.
+func AbstractErrorWithDetails(ctx context.Context, code codes.Code, message string, err error, details ...protoiface.MessageV1) error {
+	logError(ctx, code, message, err)
+	return statusError(ctx, code, message, details...)
+}
+
+// statusError returns the status error with the details and the RequestInfo of the request, without logging
+func statusError(ctx context.Context, code codes.Code, message string, details ...protoiface.MessageV1) error {
+	st := status.New(code, message)
+	if id, err := apictx.RequestIDFromContext(ctx); err == nil {
+		details = append(details, &errdetails.RequestInfo{RequestId: id})
+	}
+	if len(details) == 0 {
+		return st.Err()
+	}
+	withDetails, detailsErr := st.WithDetails(details...)
+	if detailsErr != nil {
+		getLogger().Error(detailsErr, "error attaching status details", "code", code.String())
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// ReasonError returns an error carrying ErrorInfo with the stable reason, e.g. "WORKSPACE_NOT_FOUND", in the API domain
+func ReasonError(ctx context.Context, code codes.Code, reason string, message string, err error) error {
+	return AbstractErrorWithDetails(ctx, code, message, err, &errdetails.ErrorInfo{Reason: reason, Domain: Domain})
+}
+
+// InvalidArgumentError returns an InvalidArgument error carrying the field violations of the request
+func InvalidArgumentError(ctx context.Context, message string, err error, violations ...*errdetails.BadRequest_FieldViolation) error {
+	return AbstractErrorWithDetails(ctx, codes.InvalidArgument, message, err, &errdetails.BadRequest{FieldViolations: violations})
+}
+
+// FieldViolation describes why a field of the request is invalid
+func FieldViolation(field, description string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{Field: field, Description: description}
+}
+
+// NotFoundError returns a NotFound error carrying the type and name of the missing resource
+func NotFoundError(ctx context.Context, resourceType, resourceName string, message string, err error) error {
+	return AbstractErrorWithDetails(ctx, codes.NotFound, message, err, &errdetails.ResourceInfo{
+		ResourceType: resourceType,
+		ResourceName: resourceName,
+	})
+}
+
+// RetryError returns an error carrying the delay after which the client may retry, e.g. with codes.Unavailable
+func RetryError(ctx context.Context, code codes.Code, retryDelay time.Duration, message string, err error) error {
+	return AbstractErrorWithDetails(ctx, code, message, err, &errdetails.RetryInfo{RetryDelay: durationpb.New(retryDelay)})
+}
+
+func logError(ctx context.Context, code codes.Code, message string, err error) {
+	procedure, _ := apictx.ProcedureFromContext(ctx)
+	if !sample(procedure, code, message, err) {
+		return
 	}
-	if procudure, err := apictx.ProcedureFromContext(ctx); err == nil {
-		buf.WriteString(fmt.Sprintf("Procedure: %s ", procudure))
+	values := requestValues(ctx, code, message)
+	var stack *stackError
+	if stderrors.As(err, &stack) {
+		values = append(values, "stack", string(stack.stack))
 	}
-	if ns, err := apictx.NamespaceFromContext(ctx); err == nil {
-		buf.WriteString(fmt.Sprintf("Namespace: %s ", ns))
+	getLogger().Error(err, "request error", values...)
+}
+
+type stackError struct {
+	error
+	stack []byte
+}
+
+func (s *stackError) Unwrap() error {
+	return s.error
+}
+
+// WithStack attaches a stack trace, which is logged in a field of its own. The stack is left out of the error text
+// so that sampling de-duplicates the errors by their cause.
+func WithStack(err error, stack []byte) error {
+	return &stackError{error: err, stack: stack}
+}
+
+// requestValues returns the key/value pairs describing the request the error occurred in
+func requestValues(ctx context.Context, code codes.Code, message string) []interface{} {
+	state := apictx.FromContext(ctx)
+	var values []interface{}
+	add := func(key string, value string, err error) {
+		if err == nil {
+			values = append(values, key, value)
+		}
 	}
-	buf.WriteString(fmt.Sprintf("Code: %s Message: %s Error: %v", code.String(), message, err))
-	klog.Errorf(buf.String())
-	return status.Error(code, message)
+	id, err := state.RequireRequestID()
+	add("requestID", id, err)
+	user, err := state.RequireUser()
+	add("uid", user, err)
+	actor, err := state.RequireActor()
+	add("actor", actor, err)
+	procedure, err := state.RequireProcedure()
+	add("procedure", procedure, err)
+	ws, err := state.RequireQualifiedWorkspace()
+	add("workspace", ws, err)
+	sub, err := state.RequireSubscription()
+	add("subscription", sub, err)
+	ns, err := state.RequireNamespace()
+	add("namespace", ns, err)
+	return append(values, "code", code.String(), "message", message)
 }
@@ -0,0 +1,95 @@
+package errors
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	apictx "github.com/drud/api-common/context"
+)
+
+func TestAbstractErrorDetails(t *testing.T) {
+	ctx := apictx.WithState(context.Background(), &apictx.RequestState{RequestID: "req-1"})
+	internal := fmt.Errorf("connection refused")
+	requestInfo := &errdetails.RequestInfo{RequestId: "req-1"}
+
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    codes.Code
+		wantDetails []proto.Message
+	}{
+		{
+			name:        "request info",
+			err:         AbstractError(ctx, codes.Internal, "internal error", internal),
+			wantCode:    codes.Internal,
+			wantDetails: []proto.Message{requestInfo},
+		},
+		{
+			name:     "no request id",
+			err:      AbstractError(context.Background(), codes.Internal, "internal error", internal),
+			wantCode: codes.Internal,
+		},
+		{
+			name:     "reason",
+			err:      ReasonError(ctx, codes.NotFound, "WORKSPACE_NOT_FOUND", "workspace not found", internal),
+			wantCode: codes.NotFound,
+			wantDetails: []proto.Message{
+				&errdetails.ErrorInfo{Reason: "WORKSPACE_NOT_FOUND", Domain: Domain},
+				requestInfo,
+			},
+		},
+		{
+			name:     "field violations",
+			err:      InvalidArgumentError(ctx, "invalid request", internal, FieldViolation("name", "must not be empty")),
+			wantCode: codes.InvalidArgument,
+			wantDetails: []proto.Message{
+				&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "name", Description: "must not be empty"}}},
+				requestInfo,
+			},
+		},
+		{
+			name:     "resource",
+			err:      NotFoundError(ctx, "workspace", "acme.prod", "workspace not found", internal),
+			wantCode: codes.NotFound,
+			wantDetails: []proto.Message{
+				&errdetails.ResourceInfo{ResourceType: "workspace", ResourceName: "acme.prod"},
+				requestInfo,
+			},
+		},
+		{
+			name:     "retry",
+			err:      RetryError(ctx, codes.Unavailable, 2*time.Second, "try again later", internal),
+			wantCode: codes.Unavailable,
+			wantDetails: []proto.Message{
+				&errdetails.RetryInfo{RetryDelay: durationpb.New(2 * time.Second)},
+				requestInfo,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(tt.err)
+			if st.Code() != tt.wantCode {
+				t.Errorf("code = %v, want %v", st.Code(), tt.wantCode)
+			}
+			details := st.Details()
+			if len(details) != len(tt.wantDetails) {
+				t.Fatalf("details = %v, want %v", details, tt.wantDetails)
+			}
+			for n, detail := range details {
+				msg, ok := detail.(proto.Message)
+				if !ok || !proto.Equal(msg, tt.wantDetails[n]) {
+					t.Errorf("details[%d] = %v, want %v", n, detail, tt.wantDetails[n])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package errors
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kind is an entry of the error catalogue, describing a domain error by its gRPC code, machine readable reason
+// and user facing message. Kinds are sentinels for errors.Is, e.g. errors.Is(err, WorkspaceNotFound).
+type Kind struct {
+	Code    codes.Code
+	Reason  string
+	Message string
+}
+
+var (
+	// Authentication
+	MetadataMissing      = &Kind{codes.InvalidArgument, "METADATA_MISSING", "retrieving metadata failed"}
+	TokenMissing         = &Kind{codes.Unauthenticated, "TOKEN_MISSING", "no auth details supplied"}
+	TokenInvalid         = &Kind{codes.Unauthenticated, "TOKEN_INVALID", "error validating token"}
+	CredentialsMalformed = &Kind{codes.Unauthenticated, "CREDENTIALS_MALFORMED", "malformed authorization credentials"}
+	SchemeUnsupported    = &Kind{codes.Unauthenticated, "SCHEME_UNSUPPORTED", "unsupported authorization scheme"}
+	UserNotFound         = &Kind{codes.Unauthenticated, "USER_NOT_FOUND", "error retrieving user record"}
+	ServiceTokenMissing  = &Kind{codes.Unauthenticated, "SERVICE_TOKEN_MISSING", "error retrieving service token"}
+	ImpersonationDenied  = &Kind{codes.PermissionDenied, "IMPERSONATION_DENIED", "impersonation is not permitted"}
+	DelegationDenied     = &Kind{codes.PermissionDenied, "DELEGATION_DENIED", "acting on behalf of users is not permitted"}
+
+	// Authorization
+	AuthenticationRequired = &Kind{codes.Unauthenticated, "AUTHENTICATION_REQUIRED", "authentication required"}
+	PermissionDenied       = &Kind{codes.PermissionDenied, "PERMISSION_DENIED", "permission denied"}
+	WorkspaceAccessDenied  = &Kind{codes.PermissionDenied, "WORKSPACE_ACCESS_DENIED", "permission denied for workspace"}
+	WorkspaceRequired      = &Kind{codes.PermissionDenied, "WORKSPACE_REQUIRED", "a workspace is required for request"}
+
+	// Workspaces and subscriptions
+	WorkspaceUnresolved  = &Kind{codes.NotFound, "WORKSPACE_UNRESOLVED", "unable to determine workspace for request"}
+	WorkspaceNotFound    = &Kind{codes.NotFound, "WORKSPACE_NOT_FOUND", "no valid workspace found for request"}
+	AmbiguousWorkspace   = &Kind{codes.NotFound, "AMBIGUOUS_WORKSPACE", "ambiguous workspace for request"}
+	SubscriptionInactive = &Kind{codes.FailedPrecondition, "SUBSCRIPTION_INACTIVE", "subscription is not active"}
+	QuotaExceeded        = &Kind{codes.ResourceExhausted, "QUOTA_EXCEEDED", "quota exceeded"}
+)
+
+// Error returns the reason, allowing kinds to be compared with errors.Is
+func (k *Kind) Error() string {
+	return k.Reason
+}
+
+// New logs the internal error through AbstractError and returns an Error of the kind, the reason is logged in place of
+// a nil internal error
+func (k *Kind) New(ctx context.Context, err error) error {
+	return k.newf(ctx, err, k.Message)
+}
+
+// Status returns an Error of the kind like New without logging it, for the conditions caused by clients which are
+// routine at the call site, e.g. anonymous requests to a server which does not enforce authentication
+func (k *Kind) Status(ctx context.Context, err error) error {
+	return k.wrap(k.Message, err, statusError(ctx, k.Code, k.Message, k.info()))
+}
+
+// NewSubscriptionInactive returns a SubscriptionInactive error naming the subscription
+func NewSubscriptionInactive(ctx context.Context, err error, subscription string) error {
+	return SubscriptionInactive.newf(ctx, err, fmt.Sprintf("subscription %s is not active", subscription))
+}
+
+// NewQuotaExceeded returns a QuotaExceeded error naming the exhausted resource
+func NewQuotaExceeded(ctx context.Context, err error, resource string) error {
+	return QuotaExceeded.newf(ctx, err, fmt.Sprintf("quota exceeded for %s", resource))
+}
+
+func (k *Kind) newf(ctx context.Context, err error, message string) error {
+	cause := err
+	if cause == nil {
+		cause = k
+	}
+	abstract := AbstractErrorWithDetails(ctx, k.Code, message, cause, k.info())
+	return k.wrap(message, err, abstract)
+}
+
+func (k *Kind) info() *errdetails.ErrorInfo {
+	return &errdetails.ErrorInfo{Reason: k.Reason, Domain: Domain}
+}
+
+func (k *Kind) wrap(message string, err error, abstract error) error {
+	return &Error{
+		Kind:    k,
+		Message: message,
+		Err:     err,
+		status:  status.Convert(abstract),
+	}
+}
+
+// Error is an instance of a catalogue Kind. It is converted to a status carrying the ErrorInfo reason when returned
+// from a gRPC handler, the internal error is only logged.
+type Error struct {
+	Kind    *Kind
+	Message string
+	// Err is the internal cause of the error
+	Err error
+
+	status *status.Status
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of the kind
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// GRPCStatus returns the status sent to clients
+func (e *Error) GRPCStatus() *status.Status {
+	return e.status
+}
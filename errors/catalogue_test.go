@@ -0,0 +1,83 @@
+package errors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/klogr"
+
+	apictx "github.com/drud/api-common/context"
+)
+
+func TestCatalogue(t *testing.T) {
+	cause := fmt.Errorf("subscription lapsed")
+	err := NewSubscriptionInactive(context.Background(), cause, "acme")
+	wrapped := fmt.Errorf("handler: %w", err)
+
+	if !stderrors.Is(wrapped, SubscriptionInactive) {
+		t.Errorf("errors.Is(%v, SubscriptionInactive) = false, want true", wrapped)
+	}
+	if stderrors.Is(wrapped, QuotaExceeded) {
+		t.Errorf("errors.Is(%v, QuotaExceeded) = true, want false", wrapped)
+	}
+	if !stderrors.Is(wrapped, cause) {
+		t.Errorf("errors.Is(%v, cause) = false, want true", wrapped)
+	}
+	var catalogueErr *Error
+	if !stderrors.As(wrapped, &catalogueErr) || catalogueErr.Kind != SubscriptionInactive {
+		t.Fatalf("errors.As(%v) = %v", wrapped, catalogueErr)
+	}
+
+	st := status.Convert(err)
+	if st.Code() != codes.FailedPrecondition || st.Message() != "subscription acme is not active" {
+		t.Errorf("status = %v, %q", st.Code(), st.Message())
+	}
+	details := st.Details()
+	if len(details) != 1 {
+		t.Fatalf("details = %v, want ErrorInfo", details)
+	}
+	if info, ok := details[0].(*errdetails.ErrorInfo); !ok || info.Reason != "SUBSCRIPTION_INACTIVE" || info.Domain != Domain {
+		t.Errorf("details[0] = %v, want SUBSCRIPTION_INACTIVE ErrorInfo", details[0])
+	}
+
+	// Kinds created without naming the resource keep a complete message
+	if msg := status.Convert(QuotaExceeded.New(context.Background(), nil)).Message(); msg != "quota exceeded" {
+		t.Errorf("QuotaExceeded.New() message = %q", msg)
+	}
+	if msg := status.Convert(NewQuotaExceeded(context.Background(), nil, "builds")).Message(); msg != "quota exceeded for builds" {
+		t.Errorf("NewQuotaExceeded() message = %q", msg)
+	}
+}
+
+func TestCatalogueLogging(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(NewJSONLogger(&buf))
+	defer SetLogger(klogr.New())
+	ctx := apictx.WithState(context.Background(), &apictx.RequestState{Procedure: "/test.Service/Logging"})
+
+	// Status returns the same error without logging it
+	err := TokenMissing.Status(ctx, fmt.Errorf("no auth details supplied"))
+	if status.Code(err) != codes.Unauthenticated || !stderrors.Is(err, TokenMissing) {
+		t.Errorf("TokenMissing.Status() = %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("TokenMissing.Status() logged %q", buf.String())
+	}
+
+	// The reason is logged in place of a nil internal error
+	MetadataMissing.New(ctx, nil)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log entry %q: %v", buf.String(), err)
+	}
+	if entry["error"] != "METADATA_MISSING" {
+		t.Errorf("entry[error] = %v, want METADATA_MISSING", entry["error"])
+	}
+}
@@ -0,0 +1,38 @@
+package errors
+
+import (
+	"io"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/klog/klogr"
+)
+
+var (
+	loggerMu sync.RWMutex
+	logger   = klogr.New()
+)
+
+// SetLogger replaces the logger AbstractError writes through, klog is used by default
+func SetLogger(l logr.Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+func getLogger() logr.Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+// NewJSONLogger returns a logger writing a JSON object per line to the writer, e.g. for SetLogger(NewJSONLogger(os.Stderr))
+func NewJSONLogger(w io.Writer) logr.Logger {
+	config := zap.NewProductionEncoderConfig()
+	config.EncodeTime = zapcore.ISO8601TimeEncoder
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(config), zapcore.AddSync(w), zapcore.DebugLevel)
+	return zapr.NewLogger(zap.New(core))
+}
@@ -0,0 +1,50 @@
+package errors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"k8s.io/klog/klogr"
+
+	apictx "github.com/drud/api-common/context"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(NewJSONLogger(&buf))
+	defer SetLogger(klogr.New())
+
+	ctx := apictx.WithState(context.Background(), &apictx.RequestState{
+		User:               "alice",
+		Procedure:          "/test.Service/Method",
+		QualifiedWorkspace: "acme.prod",
+		Namespace:          "ns-acme-prod",
+	})
+	AbstractError(ctx, codes.Internal, "internal error", fmt.Errorf("connection refused"))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log entry %q: %v", buf.String(), err)
+	}
+	want := map[string]string{
+		"uid":       "alice",
+		"procedure": "/test.Service/Method",
+		"workspace": "acme.prod",
+		"namespace": "ns-acme-prod",
+		"code":      "Internal",
+		"message":   "internal error",
+		"error":     "connection refused",
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("entry[%s] = %v, want %s", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["actor"]; ok {
+		t.Errorf("entry[actor] = %v, want unset", entry["actor"])
+	}
+}
@@ -0,0 +1,181 @@
+package errors
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// DefaultSamplingMaxKeys bounds the distinct errors tracked within an interval
+const DefaultSamplingMaxKeys = 1000
+
+// SamplingConfig de-duplicates the errors logged by AbstractError. Errors are keyed on the procedure, code and
+// error message, the first N of each key are logged every interval and the remainder are counted. The counts are
+// summarised every interval, and by FlushSampling, e.g. on shutdown.
+type SamplingConfig struct {
+	First    int
+	Interval time.Duration
+	// MaxKeys bounds the keys tracked within an interval, errors beyond it are always logged
+	MaxKeys int
+}
+
+// SamplingCounters are the totals of the errors logged and suppressed by sampling
+type SamplingCounters struct {
+	Logged     uint64
+	Suppressed uint64
+}
+
+type sampleKey struct {
+	procedure string
+	code      codes.Code
+	message   string
+}
+
+type sampleEntry struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+type sampler struct {
+	config SamplingConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	entries   map[sampleKey]*sampleEntry
+	lastSweep time.Time
+	stop      chan struct{}
+}
+
+var (
+	samplerMu  sync.RWMutex
+	errSampler *sampler
+
+	logged     uint64
+	suppressed uint64
+)
+
+// SetSampling enables sampling of the errors logged by AbstractError, a zero config disables sampling
+func SetSampling(config SamplingConfig) {
+	var s *sampler
+	if config.First > 0 && config.Interval > 0 {
+		if config.MaxKeys <= 0 {
+			config.MaxKeys = DefaultSamplingMaxKeys
+		}
+		s = &sampler{
+			config:  config,
+			now:     time.Now,
+			entries: make(map[sampleKey]*sampleEntry),
+			stop:    make(chan struct{}),
+		}
+		go s.run()
+	}
+	samplerMu.Lock()
+	defer samplerMu.Unlock()
+	if errSampler != nil {
+		close(errSampler.stop)
+	}
+	errSampler = s
+}
+
+// FlushSampling summarises the errors suppressed so far, including those of intervals which have not yet passed
+func FlushSampling() {
+	samplerMu.RLock()
+	s := errSampler
+	samplerMu.RUnlock()
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush(s.now(), true)
+}
+
+// run summarises the suppressed errors every interval, so counts are reported when the errors stop
+func (s *sampler) run() {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.sweep(s.now())
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Sampling returns the counters of the errors logged and suppressed, e.g. for exporting as metrics
+func Sampling() SamplingCounters {
+	return SamplingCounters{
+		Logged:     atomic.LoadUint64(&logged),
+		Suppressed: atomic.LoadUint64(&suppressed),
+	}
+}
+
+// sample reports whether the error should be logged
+func sample(procedure string, code codes.Code, message string, err error) bool {
+	samplerMu.RLock()
+	s := errSampler
+	samplerMu.RUnlock()
+	if s == nil {
+		atomic.AddUint64(&logged, 1)
+		return true
+	}
+	if err != nil {
+		message = err.Error()
+	}
+	if s.allow(sampleKey{procedure: procedure, code: code, message: message}) {
+		atomic.AddUint64(&logged, 1)
+		return true
+	}
+	atomic.AddUint64(&suppressed, 1)
+	return false
+}
+
+func (s *sampler) allow(key sampleKey) bool {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= s.config.Interval {
+		s.sweep(now)
+	}
+	entry, ok := s.entries[key]
+	if !ok {
+		if len(s.entries) >= s.config.MaxKeys {
+			return true
+		}
+		entry = &sampleEntry{start: now}
+		s.entries[key] = entry
+	}
+	if entry.count < s.config.First {
+		entry.count++
+		return true
+	}
+	entry.suppressed++
+	return false
+}
+
+// sweep summarises and forgets the entries whose interval has passed
+func (s *sampler) sweep(now time.Time) {
+	s.lastSweep = now
+	s.flush(now, false)
+}
+
+// flush summarises and forgets the entries whose interval has passed, or all entries
+func (s *sampler) flush(now time.Time, all bool) {
+	for key, entry := range s.entries {
+		if !all && now.Sub(entry.start) < s.config.Interval {
+			continue
+		}
+		if entry.suppressed > 0 {
+			getLogger().Info("suppressed repeated request errors", "procedure", key.procedure, "code", key.code.String(),
+				"error", key.message, "suppressed", entry.suppressed, "interval", s.config.Interval.String())
+		}
+		delete(s.entries, key)
+	}
+}
@@ -0,0 +1,116 @@
+package errors
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/klogr"
+
+	apictx "github.com/drud/api-common/context"
+)
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(NewJSONLogger(&buf))
+	defer SetLogger(klogr.New())
+	SetSampling(SamplingConfig{First: 2, Interval: time.Minute})
+	defer SetSampling(SamplingConfig{})
+	clock := time.Now()
+	errSampler.now = func() time.Time { return clock }
+	before := Sampling()
+
+	ctx := apictx.WithState(context.Background(), &apictx.RequestState{Procedure: "/test.Service/Method"})
+	fail := func(cause string) {
+		t.Helper()
+		err := AbstractError(ctx, codes.Unavailable, "service unavailable", fmt.Errorf("%s", cause))
+		if st := status.Convert(err); st.Code() != codes.Unavailable || st.Message() != "service unavailable" {
+			t.Errorf("AbstractError() = %v", err)
+		}
+	}
+	lines := func() int {
+		return strings.Count(buf.String(), "\n")
+	}
+
+	for n := 0; n < 5; n++ {
+		fail("connection refused")
+	}
+	if got := lines(); got != 2 {
+		t.Errorf("logged %d lines, want 2", got)
+	}
+	// a different error is keyed separately
+	fail("deadline exceeded")
+	if got := lines(); got != 3 {
+		t.Errorf("logged %d lines, want 3", got)
+	}
+	// the next interval summarises the suppressed errors and logs afresh
+	clock = clock.Add(time.Minute)
+	fail("connection refused")
+	if got := lines(); got != 5 {
+		t.Errorf("logged %d lines, want 5", got)
+	}
+	if !strings.Contains(buf.String(), `"suppressed":3`) {
+		t.Errorf("log = %s, want summary of 3 suppressed errors", buf.String())
+	}
+
+	after := Sampling()
+	if logged, suppressed := after.Logged-before.Logged, after.Suppressed-before.Suppressed; logged != 4 || suppressed != 3 {
+		t.Errorf("Sampling() logged %d suppressed %d, want 4 and 3", logged, suppressed)
+	}
+}
+
+// syncBuffer is written by the sampling ticker concurrently with the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSamplingFlush(t *testing.T) {
+	buf := &syncBuffer{}
+	SetLogger(NewJSONLogger(buf))
+	defer SetLogger(klogr.New())
+	ctx := apictx.WithState(context.Background(), &apictx.RequestState{Procedure: "/test.Service/Flush"})
+	burst := func() {
+		for n := 0; n < 3; n++ {
+			AbstractError(ctx, codes.Unavailable, "service unavailable", fmt.Errorf("connection refused"))
+		}
+	}
+
+	// FlushSampling summarises the counts before the interval has passed
+	SetSampling(SamplingConfig{First: 1, Interval: time.Hour})
+	burst()
+	FlushSampling()
+	if !strings.Contains(buf.String(), `"suppressed":2`) {
+		t.Errorf("log = %s, want summary of 2 suppressed errors", buf.String())
+	}
+
+	// The ticker summarises a burst once its interval has passed, without a later error
+	SetSampling(SamplingConfig{First: 1, Interval: 20 * time.Millisecond})
+	defer SetSampling(SamplingConfig{})
+	burst()
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(buf.String(), `"suppressed":2`) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("log = %s, want summary from the ticker", buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/grpc/metadata"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// droppedHeaders are never forwarded from external clients, the on behalf of header is only trusted from services
+// and is set by the client interceptors
+var droppedHeaders = map[string]bool{
+	apimeta.HeaderOnBehalfOf: true,
+}
+
+// passthroughHeaders are forwarded to the gRPC metadata under their own names, rather than prefixed with grpcgateway-
+var passthroughHeaders = map[string]bool{
+	apimeta.HeaderAuthToken:       true,
+	apimeta.HeaderWorkspace:       true,
+	apimeta.HeaderImpersonateUser: true,
+	apimeta.HeaderRequestID:       true,
+	"traceparent":                 true,
+	"tracestate":                  true,
+}
+
+// Config names the cookies and query parameters read when the request carries no auth or workspace headers
+type Config struct {
+	// TokenCookie carries the auth token, e.g. for browser sessions
+	TokenCookie string
+	// WorkspaceCookie carries the workspace
+	WorkspaceCookie string
+	// WorkspaceParam carries the workspace in the query, defaults to "workspace"
+	WorkspaceParam string
+}
+
+// ServeMuxOptions configure a grpc-gateway ServeMux to supply the metadata read by the state interceptors
+func ServeMuxOptions(config Config) []runtime.ServeMuxOption {
+	return []runtime.ServeMuxOption{
+		runtime.WithIncomingHeaderMatcher(HeaderMatcher),
+		runtime.WithMetadata(Annotator(config)),
+	}
+}
+
+// HeaderMatcher forwards the auth, workspace and tracing headers unchanged, drops the headers reserved for services
+// and forwards the remainder as the default matcher does
+func HeaderMatcher(key string) (string, bool) {
+	lower := strings.ToLower(key)
+	if droppedHeaders[lower] {
+		return "", false
+	}
+	if passthroughHeaders[lower] {
+		return lower, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// Annotator derives the auth token from a bearer Authorization header or the token cookie, and the workspace from the
+// query parameter or workspace cookie, when the request does not carry the x-auth-token and x-ddev-workspace headers
+func Annotator(config Config) func(context.Context, *http.Request) metadata.MD {
+	if config.WorkspaceParam == "" {
+		config.WorkspaceParam = "workspace"
+	}
+	return func(ctx context.Context, r *http.Request) metadata.MD {
+		md := metadata.MD{}
+		if r.Header.Get(textproto.CanonicalMIMEHeaderKey(apimeta.HeaderAuthToken)) == "" {
+			if token := bearerToken(r.Header.Get("Authorization")); token != "" {
+				md.Set(apimeta.HeaderAuthToken, token)
+			} else if token := cookieValue(r, config.TokenCookie); token != "" {
+				md.Set(apimeta.HeaderAuthToken, token)
+			}
+		}
+		if r.Header.Get(textproto.CanonicalMIMEHeaderKey(apimeta.HeaderWorkspace)) == "" {
+			if ws := r.URL.Query().Get(config.WorkspaceParam); ws != "" {
+				md.Set(apimeta.HeaderWorkspace, ws)
+			} else if ws := cookieValue(r, config.WorkspaceCookie); ws != "" {
+				md.Set(apimeta.HeaderWorkspace, ws)
+			}
+		}
+		return md
+	}
+}
+
+// bearerToken returns the token of bearer Authorization credentials, other schemes are left to the verifiers
+// registered with the state interceptors
+func bearerToken(authorization string) string {
+	if authorization == "" {
+		return ""
+	}
+	creds, err := apictx.ParseAuthorization(authorization)
+	if err != nil || creds.Legacy || creds.Scheme != apictx.SchemeBearer {
+		return ""
+	}
+	return creds.Token
+}
+
+func cookieValue(r *http.Request, name string) string {
+	if name == "" {
+		return ""
+	}
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"google.golang.org/grpc/metadata"
+
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func TestServeMuxOptions(t *testing.T) {
+	mux := runtime.NewServeMux(ServeMuxOptions(Config{TokenCookie: "session", WorkspaceCookie: "workspace"})...)
+
+	tests := []struct {
+		name          string
+		target        string
+		header        http.Header
+		cookies       []*http.Cookie
+		wantToken     []string
+		wantWorkspace []string
+	}{
+		{
+			name:          "headers",
+			target:        "/v1/sites",
+			header:        http.Header{"X-Auth-Token": {"token-alice"}, "X-Ddev-Workspace": {"acme.prod"}},
+			wantToken:     []string{"token-alice"},
+			wantWorkspace: []string{"acme.prod"},
+		},
+		{
+			name:          "bearer and query parameter",
+			target:        "/v1/sites?workspace=acme.dev",
+			header:        http.Header{"Authorization": {"Bearer token-alice"}},
+			wantToken:     []string{"token-alice"},
+			wantWorkspace: []string{"acme.dev"},
+		},
+		{
+			name:          "cookies",
+			target:        "/v1/sites",
+			cookies:       []*http.Cookie{{Name: "session", Value: "token-alice"}, {Name: "workspace", Value: "acme.prod"}},
+			wantToken:     []string{"token-alice"},
+			wantWorkspace: []string{"acme.prod"},
+		},
+		{
+			name:          "header preferred over query parameter",
+			target:        "/v1/sites?workspace=acme.dev",
+			header:        http.Header{"X-Ddev-Workspace": {"acme.prod"}},
+			wantWorkspace: []string{"acme.prod"},
+		},
+		{
+			name:      "on behalf of dropped",
+			target:    "/v1/sites",
+			header:    http.Header{"X-Auth-Token": {"token-alice"}, "X-Ddev-On-Behalf-Of": {"victim"}},
+			wantToken: []string{"token-alice"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			for _, cookie := range tt.cookies {
+				req.AddCookie(cookie)
+			}
+			ctx, err := runtime.AnnotateIncomingContext(req.Context(), mux, req)
+			if err != nil {
+				t.Fatalf("AnnotateIncomingContext() error = %v", err)
+			}
+			md, _ := metadata.FromIncomingContext(ctx)
+			if got := md.Get(apimeta.HeaderAuthToken); !reflect.DeepEqual(got, tt.wantToken) {
+				t.Errorf("%s = %v, want %v", apimeta.HeaderAuthToken, got, tt.wantToken)
+			}
+			if got := md.Get(apimeta.HeaderWorkspace); !reflect.DeepEqual(got, tt.wantWorkspace) {
+				t.Errorf("%s = %v, want %v", apimeta.HeaderWorkspace, got, tt.wantWorkspace)
+			}
+			for key := range md {
+				if strings.HasSuffix(key, apimeta.HeaderOnBehalfOf) {
+					t.Errorf("%s forwarded as %s", apimeta.HeaderOnBehalfOf, key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package interceptors
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"k8s.io/klog"
+
+	apictx "github.com/drud/api-common/context"
+)
+
+// AuditRecord is the structured record written for every RPC
+type AuditRecord struct {
+	Time         time.Time     `json:"time"`
+	Procedure    string        `json:"procedure"`
+	RequestID    string        `json:"request_id,omitempty"`
+	User         string        `json:"uid,omitempty"`
+	Actor        string        `json:"actor,omitempty"`
+	Service      string        `json:"service,omitempty"`
+	Workspace    string        `json:"workspace,omitempty"`
+	Subscription string        `json:"subscription,omitempty"`
+	Namespace    string        `json:"namespace,omitempty"`
+	Code         string        `json:"code"`
+	Latency      time.Duration `json:"latency_ns"`
+	// Request is the JSON encoded request message when enabled with WithAuditRequests
+	Request json.RawMessage `json:"request,omitempty"`
+}
+
+// AuditSink receives the audit records, it must be safe for concurrent use
+type AuditSink interface {
+	WriteAudit(record *AuditRecord) error
+}
+
+type jsonAuditSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONAuditSink writes each record as a line of JSON to the writer
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{enc: json.NewEncoder(w)}
+}
+
+func (j *jsonAuditSink) WriteAudit(record *AuditRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.enc.Encode(record)
+}
+
+type klogAuditSink struct{}
+
+// NewKlogAuditSink writes each record as JSON to the info log
+func NewKlogAuditSink() AuditSink {
+	return klogAuditSink{}
+}
+
+func (klogAuditSink) WriteAudit(record *AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	klog.Infof("audit: %s", b)
+	return nil
+}
+
+// ChannelAuditSink sends each record to the channel, blocking until it is received. Intended for tests.
+type ChannelAuditSink chan *AuditRecord
+
+func (c ChannelAuditSink) WriteAudit(record *AuditRecord) error {
+	c <- record
+	return nil
+}
+
+// AuditOption configures the audit interceptor
+type AuditOption func(*Auditor)
+
+// WithAuditRequests includes the request message in the records. The redacted fields are cleared before encoding, fields
+// are named either by their full name, e.g. "ddev.auth.v1.LoginRequest.password", or by name alone to redact the field
+// in every message.
+func WithAuditRequests(redact ...string) AuditOption {
+	return func(a *Auditor) {
+		a.requests = true
+		for _, field := range redact {
+			a.redact[field] = true
+		}
+	}
+}
+
+// Auditor writes an audit record for every RPC. It must be installed after the state interceptor for the records to
+// include the request state, installing it before the authorization interceptors audits denied requests too.
+type Auditor struct {
+	sink     AuditSink
+	requests bool
+	redact   map[string]bool
+	now      func() time.Time
+}
+
+func NewAuditInterceptor(sink AuditSink, opts ...AuditOption) *Auditor {
+	a := &Auditor{
+		sink:   sink,
+		redact: make(map[string]bool),
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// audit writes the record of the procedure from the request state
+func (a *Auditor) audit(ctx context.Context, procedure string, req interface{}, start time.Time, err error) {
+	state := apictx.FromContext(ctx)
+	record := &AuditRecord{
+		Time:      start,
+		Procedure: procedure,
+		Code:      status.Code(err).String(),
+		Latency:   a.now().Sub(start),
+	}
+	record.RequestID, _ = state.RequireRequestID()
+	record.User, _ = state.RequireUser()
+	record.Actor, _ = state.RequireActor()
+	record.Service, _ = state.RequireService()
+	if ws, err := state.RequireQualifiedWorkspace(); err == nil {
+		record.Workspace = ws
+	} else {
+		record.Workspace, _ = state.RequireWorkspace()
+	}
+	record.Subscription, _ = state.RequireSubscription()
+	record.Namespace, _ = state.RequireNamespace()
+	if a.requests {
+		if msg, ok := req.(proto.Message); ok {
+			record.Request = a.encodeRequest(msg)
+		}
+	}
+	if err := a.sink.WriteAudit(record); err != nil {
+		klog.Errorf("error writing audit record for %s: %v", procedure, err)
+	}
+}
+
+func (a *Auditor) encodeRequest(msg proto.Message) json.RawMessage {
+	if len(a.redact) > 0 {
+		msg = proto.Clone(msg)
+		a.redactMessage(msg.ProtoReflect())
+	}
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		klog.Errorf("error encoding audit request: %v", err)
+		return nil
+	}
+	return b
+}
+
+// redactMessage clears the redacted fields of the message and the messages nested within it
+func (a *Auditor) redactMessage(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if a.redact[string(fd.FullName())] || a.redact[string(fd.Name())] {
+			m.Clear(fd)
+			return true
+		}
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for n := 0; n < list.Len(); n++ {
+				a.redactMessage(list.Get(n).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				a.redactMessage(mv.Message())
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			a.redactMessage(v.Message())
+		}
+		return true
+	})
+}
+
+func (a *Auditor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := a.now()
+		resp, err := handler(ctx, req)
+		a.audit(ctx, info.FullMethod, req, start, err)
+		return resp, err
+	}
+}
+
+func (a *Auditor) StreamingServerInterceptor() grpc.StreamServerInterceptor {
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := a.now()
+		err := handler(srv, ss)
+		a.audit(ss.Context(), info.FullMethod, nil, start, err)
+		return err
+	}
+}
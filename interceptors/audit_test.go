@@ -0,0 +1,92 @@
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	apictx "github.com/drud/api-common/context"
+)
+
+func TestAuditInterceptor(t *testing.T) {
+	sink := make(ChannelAuditSink, 1)
+	auditor := NewAuditInterceptor(sink, WithAuditRequests("description", "google.rpc.BadRequest.FieldViolation.field"))
+	clock := time.Now()
+	auditor.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	ctx := apictx.WithState(context.Background(), &apictx.RequestState{
+		RequestID:          "req-1",
+		User:               "alice",
+		Workspace:          "prod",
+		QualifiedWorkspace: "acme.prod",
+		Subscription:       "acme",
+		Namespace:          "ns-acme-prod",
+	})
+	req := &errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+		{Field: "password", Description: "secret"},
+	}}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.PermissionDenied, "denied")
+	}
+	if _, err := auditor.UnaryServerInterceptor()(ctx, req, info, handler); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("UnaryServerInterceptor() error = %v", err)
+	}
+
+	record := <-sink
+	want := AuditRecord{
+		Time:         record.Time,
+		Procedure:    "/test.Service/Method",
+		RequestID:    "req-1",
+		User:         "alice",
+		Workspace:    "acme.prod",
+		Subscription: "acme",
+		Namespace:    "ns-acme-prod",
+		Code:         "PermissionDenied",
+		Latency:      time.Second,
+	}
+	got := *record
+	got.Request = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("record = %+v, want %+v", got, want)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(record.Request, &payload); err != nil {
+		t.Fatalf("request %s: %v", record.Request, err)
+	}
+	if bytes.Contains(record.Request, []byte("password")) || bytes.Contains(record.Request, []byte("secret")) {
+		t.Errorf("request = %s, want redacted fields removed", record.Request)
+	}
+	if violations, _ := payload["fieldViolations"].([]interface{}); len(violations) != 1 {
+		t.Errorf("request = %s, want one field violation", record.Request)
+	}
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONAuditSink(&buf)
+	for _, procedure := range []string{"/test.Service/A", "/test.Service/B"} {
+		if err := sink.WriteAudit(&AuditRecord{Procedure: procedure, Code: "OK"}); err != nil {
+			t.Fatalf("WriteAudit() error = %v", err)
+		}
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("lines = %d, want 2: %s", len(lines), buf.String())
+	}
+	var record AuditRecord
+	if err := json.Unmarshal(lines[1], &record); err != nil || record.Procedure != "/test.Service/B" {
+		t.Errorf("record = %+v, %v", record, err)
+	}
+}
@@ -0,0 +1,130 @@
+package interceptors
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	apictx "github.com/drud/api-common/context"
+	apierr "github.com/drud/api-common/errors"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// TokenSource supplies the token a service authenticates to other services with
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// ClientOption configures the client interceptors
+type ClientOption func(*clientInterceptor)
+
+// WithServiceIdentity authenticates outgoing calls with the service token instead of forwarding the user token,
+// the original user is carried in the x-ddev-on-behalf-of header
+func WithServiceIdentity(source TokenSource) ClientOption {
+	return func(c *clientInterceptor) {
+		c.service = source
+	}
+}
+
+// ClientInterceptors propagate the request state of an incoming call to outgoing calls to other API services
+type ClientInterceptors interface {
+	StreamClientInterceptor() grpc.StreamClientInterceptor
+	UnaryClientInterceptor() grpc.UnaryClientInterceptor
+}
+
+// WithPropagator replaces the W3C trace context propagated to outgoing calls
+func WithPropagator(propagator propagation.TextMapPropagator) ClientOption {
+	return func(c *clientInterceptor) {
+		c.propagator = propagator
+	}
+}
+
+type clientInterceptor struct {
+	service    TokenSource
+	propagator propagation.TextMapPropagator
+}
+
+func NewClientInterceptor(opts ...ClientOption) ClientInterceptors {
+	c := &clientInterceptor{
+		propagator: propagation.TraceContext{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// outgoingContext attaches the auth, workspace and trace metadata derived from the request state,
+// headers already present in the outgoing metadata are left untouched
+func (c *clientInterceptor) outgoingContext(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	var pairs []string
+	add := func(key, value string) {
+		if value != "" && len(md.Get(key)) == 0 {
+			pairs = append(pairs, key, value)
+		}
+	}
+
+	state := apictx.FromContext(ctx)
+	if c.service != nil {
+		token, err := c.service.Token(ctx)
+		if err != nil {
+			return ctx, apierr.ServiceTokenMissing.New(ctx, err)
+		}
+		add(apimeta.HeaderAuthToken, token)
+		if user, err := state.RequireUser(); err == nil {
+			add(apimeta.HeaderOnBehalfOf, user)
+		}
+	} else if bearer, err := state.RequireBearer(); err == nil {
+		add(apimeta.HeaderAuthToken, bearer)
+		// the bearer belongs to the actor, so the impersonation carries on downstream
+		if _, err := state.RequireActor(); err == nil {
+			if user, err := state.RequireUser(); err == nil {
+				add(apimeta.HeaderImpersonateUser, user)
+			}
+		}
+	}
+	if id, err := state.RequireRequestID(); err == nil {
+		add(apimeta.HeaderRequestID, id)
+	}
+	if ws, err := state.RequireQualifiedWorkspace(); err == nil {
+		add(apimeta.HeaderWorkspace, ws)
+	} else if ws, err := state.RequireWorkspace(); err == nil {
+		add(apimeta.HeaderWorkspace, ws)
+	}
+
+	carrier := metadataCarrier(metadata.MD{})
+	c.propagator.Inject(ctx, carrier)
+	for _, key := range carrier.Keys() {
+		add(key, carrier.Get(key))
+	}
+
+	if len(pairs) == 0 {
+		return ctx, nil
+	}
+	return metadata.AppendToOutgoingContext(ctx, pairs...), nil
+}
+
+func (c *clientInterceptor) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := c.outgoingContext(ctx)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (c *clientInterceptor) StreamClientInterceptor() grpc.StreamClientInterceptor {
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := c.outgoingContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
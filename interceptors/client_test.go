@@ -0,0 +1,83 @@
+package interceptors
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	incoming := apictx.WithState(context.Background(), &apictx.RequestState{
+		User:               "alice",
+		Bearer:             "token-alice",
+		Workspace:          "prod",
+		QualifiedWorkspace: "acme.prod",
+	})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		opts []ClientOption
+		want metadata.MD
+	}{
+		{
+			name: "forward user token",
+			ctx:  incoming,
+			want: metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.prod"),
+		},
+		{
+			name: "service identity",
+			ctx:  incoming,
+			opts: []ClientOption{WithServiceIdentity(staticTokenSource("token-service"))},
+			want: metadata.Pairs(apimeta.HeaderAuthToken, "token-service", apimeta.HeaderOnBehalfOf, "alice", apimeta.HeaderWorkspace, "acme.prod"),
+		},
+		{
+			name: "explicit workspace",
+			ctx:  metadata.AppendToOutgoingContext(incoming, apimeta.HeaderWorkspace, "acme.dev"),
+			want: metadata.Pairs(apimeta.HeaderWorkspace, "acme.dev", apimeta.HeaderAuthToken, "token-alice"),
+		},
+		{
+			name: "forward impersonation",
+			ctx:  apictx.WithState(context.Background(), &apictx.RequestState{User: "alice", Actor: "support", Bearer: "token-support"}),
+			want: metadata.Pairs(apimeta.HeaderAuthToken, "token-support", apimeta.HeaderImpersonateUser, "alice"),
+		},
+		{
+			name: "forward request id",
+			ctx:  apictx.WithState(context.Background(), &apictx.RequestState{RequestID: "req-1"}),
+			want: metadata.Pairs(apimeta.HeaderRequestID, "req-1"),
+		},
+		{
+			name: "no state",
+			ctx:  context.Background(),
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got metadata.MD
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				got, _ = metadata.FromOutgoingContext(ctx)
+				return nil
+			}
+			interceptor := NewClientInterceptor(tt.opts...).UnaryClientInterceptor()
+			if err := interceptor(tt.ctx, "/test.Service/Method", nil, nil, nil, invoker); err != nil {
+				t.Fatalf("UnaryClientInterceptor() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outgoing metadata = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
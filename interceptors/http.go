@@ -0,0 +1,86 @@
+package interceptors
+
+import (
+	"net/http"
+	"strings"
+
+	fbauth "firebase.google.com/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// Middleware wraps an http.Handler
+type Middleware func(http.Handler) http.Handler
+
+// NewHTTPMiddleware derives the request state of HTTP requests as the state interceptors do for RPCs, reading the
+// x-auth-token or Authorization and x-ddev-workspace headers. The request path is used as the procedure, e.g. for the allowlist.
+func NewHTTPMiddleware(firebaseClient *fbauth.Client, crClient client.Client, opts ...Option) Middleware {
+	return NewHTTPMiddlewareWithVerifier(NewFirebaseVerifier(firebaseClient), NewFirebaseUserResolver(firebaseClient), crClient, opts...)
+}
+
+func NewHTTPMiddlewareWithVerifier(verifier TokenVerifier, resolver UserResolver, crClient client.Client, opts ...Option) Middleware {
+	i := NewStateInterceptorWithVerifier(verifier, resolver, crClient, opts...).(*interceptor)
+	return i.httpMiddleware
+}
+
+func (i *interceptor) httpMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := metadata.NewIncomingContext(r.Context(), headerMetadata(r.Header))
+		ctx = withProcedure(ctx, r.URL.Path)
+		ctx = withRequestID(ctx)
+		if id, err := apictx.RequestIDFromContext(ctx); err == nil {
+			w.Header().Set(apimeta.HeaderRequestID, id)
+		}
+		ctx, err := i.statefulContext(ctx, r.URL.Path)
+		if err != nil {
+			st := status.Convert(err)
+			http.Error(w, st.Message(), HTTPStatusFromCode(st.Code()))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// headerMetadata converts the HTTP headers to the incoming metadata read by the state interceptor
+func headerMetadata(header http.Header) metadata.MD {
+	md := make(metadata.MD, len(header))
+	for key, values := range header {
+		md[strings.ToLower(key)] = values
+	}
+	return md
+}
+
+// HTTPStatusFromCode maps a gRPC status code to the equivalent HTTP status code
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
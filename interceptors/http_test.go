@@ -0,0 +1,81 @@
+package interceptors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	i := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+	WithEnforcement()(i)
+	WithAllowlist("/healthz")(i)
+	handler := i.httpMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ns, _ := apictx.NamespaceFromContext(r.Context())
+		user, _ := apictx.UserFromContext(r.Context())
+		w.Write([]byte(user + " " + ns))
+	}))
+
+	tests := []struct {
+		name       string
+		path       string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "auth token header",
+			path:       "/webhook",
+			header:     http.Header{"X-Auth-Token": {"token-alice"}, "X-Ddev-Workspace": {"acme.prod"}},
+			wantStatus: http.StatusOK,
+			wantBody:   "alice ns-acme-prod",
+		},
+		{
+			name:       "bearer authorization",
+			path:       "/webhook",
+			header:     http.Header{"Authorization": {"Bearer token-alice"}, "X-Ddev-Workspace": {"acme.prod"}},
+			wantStatus: http.StatusOK,
+			wantBody:   "alice ns-acme-prod",
+		},
+		{
+			name:       "missing token",
+			path:       "/webhook",
+			header:     http.Header{"X-Ddev-Workspace": {"acme.prod"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unknown workspace",
+			path:       "/webhook",
+			header:     http.Header{"X-Auth-Token": {"token-alice"}, "X-Ddev-Workspace": {"acme.dev"}},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "allowlisted path",
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+			wantBody:   " ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if rec.Header().Get(apimeta.HeaderRequestID) == "" {
+				t.Errorf("response missing %s header", apimeta.HeaderRequestID)
+			}
+		})
+	}
+}
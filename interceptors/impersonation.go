@@ -0,0 +1,54 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+
+	apictx "github.com/drud/api-common/context"
+	apierr "github.com/drud/api-common/errors"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// ImpersonationEvent describes an attempt by a user to impersonate another
+type ImpersonationEvent struct {
+	Actor   string
+	Target  string
+	Method  string
+	Allowed bool
+}
+
+// ImpersonationHook receives every impersonation attempt, e.g. to record an audit trail
+type ImpersonationHook func(ctx context.Context, event ImpersonationEvent)
+
+type impersonation struct {
+	claim string
+	hook  ImpersonationHook
+}
+
+// impersonate switches the principal to the requested user when the actor holds the impersonation claim
+func (i *interceptor) impersonate(ctx context.Context, md metadata.MD, p *principal) error {
+	values := md.Get(apimeta.HeaderImpersonateUser)
+	if len(values) == 0 || strings.TrimSpace(values[0]) == "" {
+		return nil
+	}
+	method, _ := apictx.ProcedureFromContext(ctx)
+	event := ImpersonationEvent{
+		Actor:  p.user,
+		Target: strings.TrimSpace(values[0]),
+		Method: method,
+	}
+	allowed, _ := p.token.Claims[i.impersonation.claim].(bool)
+	event.Allowed = allowed
+	if i.impersonation.hook != nil {
+		i.impersonation.hook(ctx, event)
+	}
+	if !allowed {
+		return apierr.ImpersonationDenied.New(ctx, fmt.Errorf("%s lacks the %s claim to impersonate %s", event.Actor, i.impersonation.claim, event.Target))
+	}
+	p.actor = p.user
+	p.user = event.Target
+	return nil
+}
@@ -0,0 +1,85 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	fbauth "firebase.google.com/go/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func TestImpersonation(t *testing.T) {
+	i := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+	i.verifier.(StaticTokenVerifier)["token-support"] = &fbauth.Token{UID: "support", Claims: map[string]interface{}{
+		apimeta.ClaimKeyAdmin: true,
+	}}
+	i.resolver.(StaticUserResolver)["support"] = &fbauth.UserRecord{UserInfo: &fbauth.UserInfo{UID: "support"}}
+	var events []ImpersonationEvent
+	WithEnforcement()(i)
+	WithImpersonation("", func(ctx context.Context, event ImpersonationEvent) {
+		events = append(events, event)
+	})(i)
+
+	tests := []struct {
+		name      string
+		md        metadata.MD
+		wantCode  codes.Code
+		wantUser  string
+		wantActor string
+		wantEvent *ImpersonationEvent
+	}{
+		{
+			name:      "admin impersonates user",
+			md:        metadata.Pairs(apimeta.HeaderAuthToken, "token-support", apimeta.HeaderImpersonateUser, "alice", apimeta.HeaderWorkspace, "acme.prod"),
+			wantUser:  "alice",
+			wantActor: "support",
+			wantEvent: &ImpersonationEvent{Actor: "support", Target: "alice", Method: "/test.Service/Method", Allowed: true},
+		},
+		{
+			name:      "non admin denied",
+			md:        metadata.Pairs(apimeta.HeaderAuthToken, "token-bob", apimeta.HeaderImpersonateUser, "alice", apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode:  codes.PermissionDenied,
+			wantEvent: &ImpersonationEvent{Actor: "bob", Target: "alice", Method: "/test.Service/Method"},
+		},
+		{
+			name:     "no impersonation",
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-support", apimeta.HeaderWorkspace, "acme.prod"),
+			wantUser: "support",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				if user, _ := apictx.UserFromContext(ctx); user != tt.wantUser {
+					t.Errorf("UserFromContext() = %q, want %q", user, tt.wantUser)
+				}
+				if actor, _ := apictx.ActorFromContext(ctx); actor != tt.wantActor {
+					t.Errorf("ActorFromContext() = %q, want %q", actor, tt.wantActor)
+				}
+				if record, err := apictx.UserRecordFromContext(ctx); err != nil || record.UID != tt.wantUser {
+					t.Errorf("UserRecordFromContext() = %v, %v", record, err)
+				}
+				return nil, nil
+			}
+			_, err := i.UnaryServerInterceptor()(ctx, nil, info, handler)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("UnaryServerInterceptor() code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+			switch {
+			case tt.wantEvent == nil && len(events) != 0:
+				t.Errorf("hook events = %v, want none", events)
+			case tt.wantEvent != nil && (len(events) != 1 || events[0] != *tt.wantEvent):
+				t.Errorf("hook events = %v, want %v", events, *tt.wantEvent)
+			}
+		})
+	}
+}
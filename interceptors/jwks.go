@@ -0,0 +1,247 @@
+package interceptors
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/groupcache/singleflight"
+	"k8s.io/klog"
+)
+
+const (
+	// DefaultJWKSRefresh is the interval after which a cached key set is reloaded
+	DefaultJWKSRefresh = time.Hour
+	// minJWKSRefresh rate limits reloads triggered by tokens signed with an unknown key, and retries of failed reloads
+	minJWKSRefresh = 10 * time.Second
+	// jwksFetchTimeout bounds a reload, which is shared by the verifications waiting on it
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// KeySet provides the public keys used to validate token signatures
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// JWKS is a KeySet loaded from a JSON web key set document which is cached and reloaded to support key rotation
+type JWKS struct {
+	fetch   func(ctx context.Context) ([]byte, error)
+	refresh time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// attempted is the time of the last reload, successful or not, and err the error of a failed first load
+	attempted time.Time
+	err       error
+
+	group singleflight.Group
+}
+
+// NewJWKSFromFile returns a key set read from a local file, reloaded after the refresh interval
+func NewJWKSFromFile(path string, refresh time.Duration) *JWKS {
+	return newJWKS(func(ctx context.Context) ([]byte, error) {
+		return ioutil.ReadFile(path)
+	}, refresh)
+}
+
+// NewJWKSFromURL returns a key set retrieved from an HTTP endpoint, reloaded after the refresh interval
+func NewJWKSFromURL(url string, httpClient *http.Client, refresh time.Duration) *JWKS {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return newJWKS(func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status retrieving key set: %s", resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}, refresh)
+}
+
+func newJWKS(fetch func(ctx context.Context) ([]byte, error), refresh time.Duration) *JWKS {
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	return &JWKS{
+		fetch:   fetch,
+		refresh: refresh,
+		now:     time.Now,
+	}
+}
+
+// Key returns the public key with the given key ID, reloading the key set when it is stale or the key is unknown.
+// Concurrent reloads are shared, and reloads are attempted at most once per minimum refresh interval.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, ok, reload := j.lookup(kid)
+	if reload {
+		_, err := j.group.Do("", func() (interface{}, error) {
+			return nil, j.load()
+		})
+		if err != nil && ok {
+			// Keep serving the previous keys if a reload fails
+			return key, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		key, ok, _ = j.lookup(kid)
+	}
+	if !ok {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.keys == nil && j.err != nil {
+			return nil, j.err
+		}
+		return nil, fmt.Errorf("no key found for key id %q", kid)
+	}
+	return key, nil
+}
+
+// lookup returns the key and whether the key set is due to be reloaded
+func (j *JWKS) lookup(kid string) (crypto.PublicKey, bool, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	key, ok := j.keys[kid]
+	stale := j.keys == nil || now.Sub(j.fetched) > j.refresh
+	// An unknown key ID most likely indicates the keys have been rotated
+	return key, ok, (stale || !ok) && now.Sub(j.attempted) > minJWKSRefresh
+}
+
+// load fetches the key set without holding the lock, the fetch is not tied to the request which triggered it
+func (j *JWKS) load() error {
+	j.mu.Lock()
+	j.attempted = j.now()
+	j.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	keys, err := j.parse(ctx)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err != nil {
+		j.err = err
+		return err
+	}
+	j.keys = keys
+	j.fetched = j.now()
+	j.err = nil
+	return nil
+}
+
+func (j *JWKS) parse(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	data, err := j.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading key set: %v", err)
+	}
+	var set jsonWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("error parsing key set: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Keys of unsupported types or curves do not prevent the remaining keys being used
+			if debug {
+				klog.Infof("skipping key %q: %v", jwk.Kid, err)
+			}
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,90 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apictx "github.com/drud/api-common/context"
+	apierr "github.com/drud/api-common/errors"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// MembershipVerifier confirms a user belongs to the workspace a request resolved to,
+// returning a PermissionDenied status when they do not
+type MembershipVerifier interface {
+	VerifyMembership(ctx context.Context, uid string, namespace string) error
+}
+
+type namespaceMembership struct {
+	crClient client.Client
+}
+
+// NewNamespaceMembership returns a MembershipVerifier reading workspace members from the namespace
+// member labels (member.ddev.live/<uid>) or the ddev.live/members annotation
+func NewNamespaceMembership(crClient client.Client) MembershipVerifier {
+	return &namespaceMembership{crClient: crClient}
+}
+
+func (n *namespaceMembership) VerifyMembership(ctx context.Context, uid string, namespace string) error {
+	var ns v1.Namespace
+	if err := n.crClient.Get(ctx, client.ObjectKey{Name: namespace}, &ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return apierr.WorkspaceAccessDenied.New(ctx, err)
+		}
+		return apierr.AbstractError(ctx, codes.Internal, "an internal error occured verifying workspace membership", err)
+	}
+	if _, ok := ns.Labels[apimeta.LabelKeyMemberPrefix+uid]; ok {
+		return nil
+	}
+	for _, member := range strings.Split(ns.Annotations[apimeta.AnnotationKeyMembers], ",") {
+		if strings.TrimSpace(member) == uid {
+			return nil
+		}
+	}
+	return apierr.WorkspaceAccessDenied.New(ctx, fmt.Errorf("%s is not a member of %s", uid, namespace))
+}
+
+func (i *interceptor) verifyMembership(ctx context.Context, ns string) error {
+	uid, err := apictx.UserFromContext(ctx)
+	if err != nil {
+		return apierr.WorkspaceAccessDenied.New(ctx, err)
+	}
+	return i.membership.VerifyMembership(ctx, uid, ns)
+}
+
+// verifyScope confirms the workspace is listed in the workspaces claim of the token, when the token carries the claim
+func verifyScope(ctx context.Context, ws *workspaceState) error {
+	token, err := apictx.AuthTokenFromContext(ctx)
+	if err != nil {
+		return nil
+	}
+	iface, ok := token.Claims[apimeta.ClaimKeyWorkspaces]
+	if !ok {
+		return nil
+	}
+	var scopes []string
+	switch claim := iface.(type) {
+	case []string:
+		scopes = claim
+	case []interface{}:
+		for _, scope := range claim {
+			if str, ok := scope.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+	}
+	if ws.qualified != "" {
+		for _, scope := range scopes {
+			if scope == ws.qualified {
+				return nil
+			}
+		}
+	}
+	return apierr.WorkspaceAccessDenied.New(ctx, fmt.Errorf("token for %s is not scoped to workspace %s", token.UID, ws.qualified))
+}
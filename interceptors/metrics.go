@@ -0,0 +1,227 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	apictx "github.com/drud/api-common/context"
+	apierr "github.com/drud/api-common/errors"
+)
+
+// otherSubscription replaces the subscription label of subscriptions excluded by the cardinality guards
+const otherSubscription = "other"
+
+// MetricsConfig configures the RPC and state interceptor metrics
+type MetricsConfig struct {
+	// Namespace prefixes the metric names, defaults to "api"
+	Namespace string
+	// SubscriptionLabel labels the RPC metrics by the subscription of the request
+	SubscriptionLabel bool
+	// AllowSubscriptions, when set, limits the subscriptions labelled, the remainder are labelled "other"
+	AllowSubscriptions []string
+	// DenySubscriptions are labelled "other"
+	DenySubscriptions []string
+	// Buckets of the latency histograms, defaults to prometheus.DefBuckets
+	Buckets []float64
+}
+
+// Metrics is a prometheus.Collector of the RPC metrics recorded by its interceptors and the state interceptor
+// metrics, recorded when supplied to the state interceptor with WithMetrics
+type Metrics struct {
+	config MetricsConfig
+	allow  map[string]bool
+	deny   map[string]bool
+
+	requests         *prometheus.CounterVec
+	latency          *prometheus.HistogramVec
+	tokenLatency     prometheus.Histogram
+	tokenFailures    *prometheus.CounterVec
+	namespaceLatency prometheus.Histogram
+	namespaceErrors  *prometheus.CounterVec
+	// legacyAuthorization counts the requests authorized without a scheme by whether they were accepted
+	legacyAuthorization *prometheus.CounterVec
+	errorsLogged        prometheus.CounterFunc
+	errorsSuppressed    prometheus.CounterFunc
+}
+
+func NewMetrics(config MetricsConfig) *Metrics {
+	if config.Namespace == "" {
+		config.Namespace = "api"
+	}
+	if config.Buckets == nil {
+		config.Buckets = prometheus.DefBuckets
+	}
+	m := &Metrics{
+		config: config,
+		deny:   make(map[string]bool, len(config.DenySubscriptions)),
+	}
+	if len(config.AllowSubscriptions) > 0 {
+		m.allow = make(map[string]bool, len(config.AllowSubscriptions))
+		for _, sub := range config.AllowSubscriptions {
+			m.allow[sub] = true
+		}
+	}
+	for _, sub := range config.DenySubscriptions {
+		m.deny[sub] = true
+	}
+
+	labels := []string{"procedure", "code"}
+	if config.SubscriptionLabel {
+		labels = append(labels, "subscription")
+	}
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: config.Namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "Total RPCs handled by procedure and status code.",
+	}, labels)
+	m.latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: config.Namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the RPCs handled by procedure and status code.",
+		Buckets:   config.Buckets,
+	}, labels)
+	m.tokenLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: config.Namespace,
+		Subsystem: "state",
+		Name:      "token_verification_duration_seconds",
+		Help:      "Latency of verifying request tokens.",
+		Buckets:   config.Buckets,
+	})
+	m.tokenFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: config.Namespace,
+		Subsystem: "state",
+		Name:      "token_verification_failures_total",
+		Help:      "Total token verification failures by reason.",
+	}, []string{"reason"})
+	m.namespaceLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: config.Namespace,
+		Subsystem: "state",
+		Name:      "namespace_lookup_duration_seconds",
+		Help:      "Latency of resolving the namespace of a workspace.",
+		Buckets:   config.Buckets,
+	})
+	m.namespaceErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: config.Namespace,
+		Subsystem: "state",
+		Name:      "namespace_lookup_failures_total",
+		Help:      "Total namespace lookups failing by reason.",
+	}, []string{"reason"})
+	m.legacyAuthorization = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: config.Namespace,
+		Subsystem: "state",
+		Name:      "legacy_authorization_total",
+		Help:      "Total requests supplying authorization without a scheme by whether they were accepted.",
+	}, []string{"accepted"})
+	m.errorsLogged = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: config.Namespace,
+		Subsystem: "errors",
+		Name:      "logged_total",
+		Help:      "Total errors logged through AbstractError.",
+	}, func() float64 { return float64(apierr.Sampling().Logged) })
+	m.errorsSuppressed = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: config.Namespace,
+		Subsystem: "errors",
+		Name:      "suppressed_total",
+		Help:      "Total errors suppressed by AbstractError log sampling.",
+	}, func() float64 { return float64(apierr.Sampling().Suppressed) })
+	return m
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requests, m.latency, m.tokenLatency, m.tokenFailures, m.namespaceLatency, m.namespaceErrors, m.legacyAuthorization,
+		m.errorsLogged, m.errorsSuppressed,
+	}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// subscriptionLabel applies the cardinality guards to the subscription of the request. The subscription is only
+// used once the workspace resolved to a namespace, otherwise callers could create a series per header value.
+func (m *Metrics) subscriptionLabel(ctx context.Context) string {
+	if _, err := apictx.NamespaceFromContext(ctx); err != nil {
+		return otherSubscription
+	}
+	sub, err := apictx.SubscriptionFromContext(ctx)
+	if err != nil {
+		return ""
+	}
+	if m.deny[sub] || (m.allow != nil && !m.allow[sub]) {
+		return otherSubscription
+	}
+	return sub
+}
+
+func (m *Metrics) observe(ctx context.Context, procedure string, start time.Time, err error) {
+	labels := []string{procedure, status.Code(err).String()}
+	if m.config.SubscriptionLabel {
+		labels = append(labels, m.subscriptionLabel(ctx))
+	}
+	m.requests.WithLabelValues(labels...).Inc()
+	m.latency.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) observeToken(start time.Time, kind *apierr.Kind) {
+	m.tokenLatency.Observe(time.Since(start).Seconds())
+	if kind != nil {
+		m.tokenFailures.WithLabelValues(kind.Reason).Inc()
+	}
+}
+
+// UnaryServerInterceptor records the RPC metrics, install it after the state interceptor to label by subscription
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observe(ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func (m *Metrics) StreamingServerInterceptor() grpc.StreamServerInterceptor {
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observe(ss.Context(), info.FullMethod, start, err)
+		return err
+	}
+}
+
+type instrumentedResolver struct {
+	resolver NamespaceResolver
+	metrics  *Metrics
+}
+
+func (r *instrumentedResolver) ResolveNamespace(ctx context.Context, subscription, workspace string) (string, error) {
+	start := time.Now()
+	namespace, err := r.resolver.ResolveNamespace(ctx, subscription, workspace)
+	r.metrics.namespaceLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		reason := "ERROR"
+		var catalogueErr *apierr.Error
+		if errors.As(err, &catalogueErr) {
+			reason = catalogueErr.Kind.Reason
+		}
+		r.metrics.namespaceErrors.WithLabelValues(reason).Inc()
+	}
+	return namespace, err
+}
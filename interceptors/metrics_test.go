@@ -0,0 +1,102 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics(MetricsConfig{
+		SubscriptionLabel:  true,
+		AllowSubscriptions: []string{"acme"},
+	})
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(metrics); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	i := testInterceptor(
+		testNamespace("ns-acme-prod", "acme", "prod"),
+		testNamespace("ns-acme-dup1", "acme", "dup"),
+		testNamespace("ns-acme-dup2", "acme", "dup"),
+		testNamespace("ns-globex-prod", "globex", "prod"),
+	)
+	WithMetrics(metrics)(i)
+	i.namespaces = &instrumentedResolver{resolver: i.namespaces, metrics: metrics}
+
+	chain := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return i.UnaryServerInterceptor()(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return metrics.UnaryServerInterceptor()(ctx, req, info, handler)
+		})
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	for _, md := range []metadata.MD{
+		metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.prod"),
+		metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.prod"),
+		metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.dup"),
+		metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.missing"),
+		metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "globex.prod"),
+		metadata.Pairs(apimeta.HeaderAuthToken, "token-unknown", apimeta.HeaderWorkspace, "acme.prod"),
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		chain(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.Unimplemented, "unimplemented")
+		})
+	}
+
+	tests := []struct {
+		name      string
+		collector prometheus.Collector
+		want      float64
+	}{
+		{"acme requests", metrics.requests.WithLabelValues(info.FullMethod, "Unimplemented", "acme"), 3},
+		// globex is not allowed, the ambiguous and missing workspaces never resolved
+		{"guarded subscription requests", metrics.requests.WithLabelValues(info.FullMethod, "Unimplemented", otherSubscription), 3},
+		{"invalid tokens", metrics.tokenFailures.WithLabelValues("TOKEN_INVALID"), 1},
+		{"ambiguous workspaces", metrics.namespaceErrors.WithLabelValues("AMBIGUOUS_WORKSPACE"), 1},
+		{"missing workspaces", metrics.namespaceErrors.WithLabelValues("WORKSPACE_NOT_FOUND"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testutil.ToFloat64(tt.collector); got != tt.want {
+				t.Errorf("value = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := registry.Gather(); err != nil {
+		t.Errorf("Gather() error = %v", err)
+	}
+}
+
+func TestMetricsUnresolvedSubscription(t *testing.T) {
+	metrics := NewMetrics(MetricsConfig{SubscriptionLabel: true})
+	i := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+	WithMetrics(metrics)(i)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	for _, ws := range []string{"r1.x", "r2.x", "r3.x"} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(apimeta.HeaderWorkspace, ws))
+		i.UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return metrics.UnaryServerInterceptor()(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+		})
+	}
+	series := make(chan prometheus.Metric, 10)
+	metrics.requests.Collect(series)
+	close(series)
+	if got := len(series); got != 1 {
+		t.Errorf("request series = %d, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.requests.WithLabelValues(info.FullMethod, "OK", otherSubscription)); got != 3 {
+		t.Errorf("unresolved subscription requests = %v, want 3", got)
+	}
+}
@@ -0,0 +1,232 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/groupcache/lru"
+	"google.golang.org/grpc/codes"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	apierr "github.com/drud/api-common/errors"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+const labelKeyDisplayName = "ddev.live/displayname"
+
+// NamespaceResolver resolves a subscription qualified workspace to the namespace backing it
+type NamespaceResolver interface {
+	ResolveNamespace(ctx context.Context, subscription, workspace string) (string, error)
+}
+
+type listResolver struct {
+	crClient client.Client
+}
+
+// NewNamespaceResolver returns a NamespaceResolver listing namespaces by their workspace labels for every lookup
+func NewNamespaceResolver(crClient client.Client) NamespaceResolver {
+	return &listResolver{crClient: crClient}
+}
+
+func (l *listResolver) ResolveNamespace(ctx context.Context, subscription, workspace string) (string, error) {
+	names, err := l.list(ctx, subscription, workspace)
+	if err != nil {
+		return "", err
+	}
+	return selectNamespace(ctx, names)
+}
+
+func (l *listResolver) list(ctx context.Context, subscription, workspace string) ([]string, error) {
+	selector := labels.NewSelector()
+	displayReqs, err := labels.ParseToRequirements(fmt.Sprintf("%s==%s", labelKeyDisplayName, workspace))
+	if err != nil {
+		return nil, apierr.AbstractError(ctx, codes.Internal, "unable to determine workspace for request", err)
+	}
+
+	subscriptionReqs, err := labels.ParseToRequirements(fmt.Sprintf("%s==%s", apimeta.LabelKeySubscriptionStub, subscription))
+	if err != nil {
+		return nil, apierr.AbstractError(ctx, codes.Internal, "unable to determine workspace for request", err)
+	}
+	selector = selector.Add(displayReqs...)
+	selector = selector.Add(subscriptionReqs...)
+
+	var namespaceList v1.NamespaceList
+	if err := l.crClient.List(ctx, &namespaceList, &client.ListOptions{
+		LabelSelector: selector,
+	}); err != nil {
+		return nil, apierr.AbstractError(ctx, codes.Internal, "an internal error occured retrieving workspaces", err)
+	}
+	names := make([]string, 0, len(namespaceList.Items))
+	for _, ns := range namespaceList.Items {
+		names = append(names, ns.Name)
+	}
+	return names, nil
+}
+
+func selectNamespace(ctx context.Context, names []string) (string, error) {
+	if len(names) > 1 {
+		return "", apierr.AmbiguousWorkspace.New(ctx, fmt.Errorf("namespaces %v match the workspace", names))
+	}
+	if len(names) == 0 {
+		return "", apierr.WorkspaceNotFound.Status(ctx, nil)
+	}
+	return names[0], nil
+}
+
+type namespaceEntry struct {
+	names   []string
+	expires time.Time
+}
+
+// DefaultNamespaceCacheEntries bounds the entries of a CachedNamespaceResolver by default
+const DefaultNamespaceCacheEntries = 10000
+
+// NamespaceCacheOption configures a CachedNamespaceResolver
+type NamespaceCacheOption func(*CachedNamespaceResolver)
+
+// WithNamespaceCacheEntries bounds the cache size, evicting the least recently used entries. Zero means no limit
+func WithNamespaceCacheEntries(max int) NamespaceCacheOption {
+	return func(c *CachedNamespaceResolver) {
+		c.maxEntries = max
+	}
+}
+
+// CachedNamespaceResolver is a NamespaceResolver caching namespace lookups indexed by their workspace labels.
+// Entries expire after the TTL, and are invalidated immediately when the resolver is attached to a namespace informer.
+// Workspaces without namespaces are cached too, the size is bounded as callers choose the workspaces looked up.
+type CachedNamespaceResolver struct {
+	lister     *listResolver
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries *lru.Cache
+
+	hits   uint64
+	misses uint64
+}
+
+// NewCachedNamespaceResolver returns a caching NamespaceResolver backed by the supplied client
+func NewCachedNamespaceResolver(crClient client.Client, ttl time.Duration, opts ...NamespaceCacheOption) *CachedNamespaceResolver {
+	c := &CachedNamespaceResolver{
+		lister:     &listResolver{crClient: crClient},
+		ttl:        ttl,
+		maxEntries: DefaultNamespaceCacheEntries,
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.entries = lru.New(c.maxEntries)
+	return c
+}
+
+func cacheKey(subscription, workspace string) string {
+	return subscription + "." + workspace
+}
+
+func (c *CachedNamespaceResolver) ResolveNamespace(ctx context.Context, subscription, workspace string) (string, error) {
+	key := cacheKey(subscription, workspace)
+	if entry, ok := c.lookup(key); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return selectNamespace(ctx, entry.names)
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	names, err := c.lister.list(ctx, subscription, workspace)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.entries.Add(key, namespaceEntry{
+		names:   names,
+		expires: c.now().Add(c.ttl),
+	})
+	c.mu.Unlock()
+	return selectNamespace(ctx, names)
+}
+
+func (c *CachedNamespaceResolver) lookup(key string) (namespaceEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	iface, ok := c.entries.Get(key)
+	if !ok {
+		return namespaceEntry{}, false
+	}
+	entry := iface.(namespaceEntry)
+	if !c.now().Before(entry.expires) {
+		c.entries.Remove(key)
+		return namespaceEntry{}, false
+	}
+	return entry, true
+}
+
+// Len returns the number of cached workspaces
+func (c *CachedNamespaceResolver) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
+
+// Invalidate drops the cached namespaces for a workspace
+func (c *CachedNamespaceResolver) Invalidate(subscription, workspace string) {
+	c.mu.Lock()
+	c.entries.Remove(cacheKey(subscription, workspace))
+	c.mu.Unlock()
+}
+
+// Flush drops all cached namespaces
+func (c *CachedNamespaceResolver) Flush() {
+	c.mu.Lock()
+	c.entries.Clear()
+	c.mu.Unlock()
+}
+
+// Hits returns the number of lookups served from the cache
+func (c *CachedNamespaceResolver) Hits() uint64 {
+	return atomic.LoadUint64(&c.hits)
+}
+
+// Misses returns the number of lookups which required listing namespaces
+func (c *CachedNamespaceResolver) Misses() uint64 {
+	return atomic.LoadUint64(&c.misses)
+}
+
+// NamespaceInformer is satisfied by both client-go shared informers and controller-runtime cache informers
+type NamespaceInformer interface {
+	AddEventHandler(handler toolscache.ResourceEventHandler)
+}
+
+// Watch invalidates cached entries as the namespaces they were derived from change
+func (c *CachedNamespaceResolver) Watch(informer NamespaceInformer) {
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: c.invalidateObject,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.invalidateObject(oldObj)
+			c.invalidateObject(newObj)
+		},
+		DeleteFunc: c.invalidateObject,
+	})
+}
+
+func (c *CachedNamespaceResolver) invalidateObject(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	ns, ok := obj.(*v1.Namespace)
+	if !ok {
+		return
+	}
+	workspace, ok := ns.Labels[labelKeyDisplayName]
+	if !ok {
+		return
+	}
+	c.Invalidate(ns.Labels[apimeta.LabelKeySubscriptionStub], workspace)
+}
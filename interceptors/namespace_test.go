@@ -0,0 +1,95 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type testInformer struct {
+	handler toolscache.ResourceEventHandler
+}
+
+func (t *testInformer) AddEventHandler(handler toolscache.ResourceEventHandler) {
+	t.handler = handler
+}
+
+func TestCachedNamespaceResolver(t *testing.T) {
+	crClient := fake.NewFakeClient(
+		testNamespace("ns-acme-prod", "acme", "prod"),
+		testNamespace("ns-acme-dup1", "acme", "dup"),
+		testNamespace("ns-acme-dup2", "acme", "dup"),
+	)
+	resolver := NewCachedNamespaceResolver(crClient, time.Minute)
+	informer := &testInformer{}
+	resolver.Watch(informer)
+
+	tests := []struct {
+		name          string
+		workspace     string
+		wantNamespace string
+		wantErr       string
+	}{
+		{name: "found", workspace: "prod", wantNamespace: "ns-acme-prod"},
+		{name: "ambiguous", workspace: "dup", wantErr: "ambiguous workspace for request"},
+		{name: "not found", workspace: "staging", wantErr: "no valid workspace found for request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The second lookup is served from the cache and must behave identically
+			for n := 0; n < 2; n++ {
+				ns, err := resolver.ResolveNamespace(context.Background(), "acme", tt.workspace)
+				if ns != tt.wantNamespace {
+					t.Errorf("ResolveNamespace() = %q, want %q", ns, tt.wantNamespace)
+				}
+				if tt.wantErr != "" {
+					if s := status.Convert(err); s.Code() != codes.NotFound || s.Message() != tt.wantErr {
+						t.Errorf("ResolveNamespace() error = %v, want NotFound %q", err, tt.wantErr)
+					}
+				} else if err != nil {
+					t.Errorf("ResolveNamespace() error = %v", err)
+				}
+			}
+		})
+	}
+	if resolver.Hits() != 3 || resolver.Misses() != 3 {
+		t.Errorf("Hits() = %d, Misses() = %d, want 3 and 3", resolver.Hits(), resolver.Misses())
+	}
+
+	// A namespace created for the missing workspace invalidates the cached miss
+	staging := testNamespace("ns-acme-staging", "acme", "staging")
+	if err := crClient.Create(context.Background(), staging); err != nil {
+		t.Fatal(err)
+	}
+	informer.handler.OnAdd(staging)
+	if ns, err := resolver.ResolveNamespace(context.Background(), "acme", "staging"); err != nil || ns != "ns-acme-staging" {
+		t.Errorf("ResolveNamespace() = %q, %v after invalidation", ns, err)
+	}
+	if resolver.Misses() != 4 {
+		t.Errorf("Misses() = %d, want 4", resolver.Misses())
+	}
+}
+
+func TestCachedNamespaceResolverEntries(t *testing.T) {
+	resolver := NewCachedNamespaceResolver(fake.NewFakeClient(testNamespace("ns-acme-prod", "acme", "prod")), time.Minute,
+		WithNamespaceCacheEntries(2))
+
+	// Lookups of unknown workspaces evict the least recently used entries rather than growing the cache
+	for _, ws := range []string{"prod", "r1", "r2", "r3"} {
+		resolver.ResolveNamespace(context.Background(), "acme", ws)
+	}
+	if n := resolver.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+	if ns, err := resolver.ResolveNamespace(context.Background(), "acme", "prod"); err != nil || ns != "ns-acme-prod" {
+		t.Errorf("ResolveNamespace() = %q, %v after eviction", ns, err)
+	}
+	if resolver.Misses() != 5 {
+		t.Errorf("Misses() = %d, want 5", resolver.Misses())
+	}
+}
@@ -0,0 +1,244 @@
+package interceptors
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	fbauth "firebase.google.com/go/auth"
+
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// OIDCConfig configures validation of OIDC JWT bearer tokens
+type OIDCConfig struct {
+	// Issuer is the required iss claim
+	Issuer string
+	// Audiences are the accepted aud claim values, a token must carry at least one of them
+	Audiences []string
+	// ClockSkew is the tolerance applied when checking exp, nbf and iat
+	ClockSkew time.Duration
+	// UserClaim is the claim used as the request UID, defaults to sub
+	UserClaim string
+	// WorkspaceClaim is the claim mapped onto the default workspace claim, defaults to default_workspace
+	WorkspaceClaim string
+	// Keys provides the keys used to validate token signatures
+	Keys KeySet
+}
+
+// SignInProviderOIDC is the sign in provider of the tokens verified by an OIDC verifier. Their subjects have no
+// firebase user record, the record of the request is built from the token claims instead.
+const SignInProviderOIDC = "oidc"
+
+type oidcVerifier struct {
+	config OIDCConfig
+	now    func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// NewOIDCVerifier returns a TokenVerifier which validates RS256/ES256 signed JWTs against a JSON web key set. The user
+// records of their subjects are built from the token claims rather than retrieved from the UserResolver.
+func NewOIDCVerifier(config OIDCConfig) (TokenVerifier, error) {
+	if config.Issuer == "" {
+		return nil, fmt.Errorf("oidc verifier requires an issuer")
+	}
+	if len(config.Audiences) == 0 {
+		return nil, fmt.Errorf("oidc verifier requires at least one audience")
+	}
+	if config.Keys == nil {
+		return nil, fmt.Errorf("oidc verifier requires a key set")
+	}
+	if config.UserClaim == "" {
+		config.UserClaim = "sub"
+	}
+	if config.WorkspaceClaim == "" {
+		config.WorkspaceClaim = apimeta.ClaimKeyDefaultWorkspace
+	}
+	return &oidcVerifier{
+		config: config,
+		now:    time.Now,
+	}, nil
+}
+
+func (o *oidcVerifier) VerifyIDToken(ctx context.Context, idToken string) (*fbauth.Token, error) {
+	segments := strings.Split(idToken, ".")
+	if len(segments) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(segments[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	key, err := o.config.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	if err := verifySignature(header.Alg, key, segments[0]+"."+segments[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(segments[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	return o.validateClaims(claims)
+}
+
+func (o *oidcVerifier) validateClaims(claims map[string]interface{}) (*fbauth.Token, error) {
+	now := o.now()
+	skew := o.config.ClockSkew
+
+	token := &fbauth.Token{
+		Claims: claims,
+	}
+	token.Issuer, _ = claims["iss"].(string)
+	if token.Issuer != o.config.Issuer {
+		return nil, fmt.Errorf("invalid issuer %q", token.Issuer)
+	}
+	audience, ok := matchAudience(claims["aud"], o.config.Audiences)
+	if !ok {
+		return nil, fmt.Errorf("token audience is not accepted")
+	}
+	token.Audience = audience
+
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+	if now.After(time.Unix(exp, 0).Add(skew)) {
+		return nil, fmt.Errorf("token has expired")
+	}
+	token.Expires = exp
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(skew).Before(time.Unix(nbf, 0)) {
+		return nil, fmt.Errorf("token is not yet valid")
+	}
+	if iat, ok := numericClaim(claims, "iat"); ok {
+		if now.Add(skew).Before(time.Unix(iat, 0)) {
+			return nil, fmt.Errorf("token issued in the future")
+		}
+		token.IssuedAt = iat
+	}
+	token.AuthTime, _ = numericClaim(claims, "auth_time")
+	token.Subject, _ = claims["sub"].(string)
+
+	uid, _ := claims[o.config.UserClaim].(string)
+	if uid == "" {
+		return nil, fmt.Errorf("token has no %s claim", o.config.UserClaim)
+	}
+	token.UID = uid
+	token.Firebase.SignInProvider = SignInProviderOIDC
+	if ws, ok := claims[o.config.WorkspaceClaim].(string); ok {
+		claims[apimeta.ClaimKeyDefaultWorkspace] = ws
+	}
+	return token, nil
+}
+
+// userRecordFromClaims returns the user record of the subject of a token verified by an OIDC verifier, built from its
+// standard profile claims. Users other than the subject, e.g. impersonated users, are not built from the claims.
+func userRecordFromClaims(token *fbauth.Token, uid string) (*fbauth.UserRecord, bool) {
+	if token == nil || token.Firebase.SignInProvider != SignInProviderOIDC || token.UID != uid {
+		return nil, false
+	}
+	info := &fbauth.UserInfo{UID: uid, ProviderID: token.Issuer}
+	info.Email, _ = token.Claims["email"].(string)
+	info.DisplayName, _ = token.Claims["name"].(string)
+	info.PhotoURL, _ = token.Claims["picture"].(string)
+	verified, _ := token.Claims["email_verified"].(bool)
+	return &fbauth.UserRecord{UserInfo: info, EmailVerified: verified}, true
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("signing algorithm %s does not match RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("signing algorithm %s does not match EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", key)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	if v, ok := claims[name].(float64); ok {
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func matchAudience(aud interface{}, accepted []string) (string, bool) {
+	var audiences []string
+	switch v := aud.(type) {
+	case string:
+		audiences = []string{v}
+	case []interface{}:
+		for _, elem := range v {
+			if s, ok := elem.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	for _, a := range audiences {
+		for _, b := range accepted {
+			if a == b {
+				return a, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,342 @@
+package interceptors
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+type testSigner struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+func (s *testSigner) jwk() jsonWebKey {
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	switch k := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{Kty: "RSA", Kid: s.kid, N: enc(k.N.Bytes()), E: enc(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return jsonWebKey{Kty: "EC", Kid: s.kid, Crv: "P-256", X: enc(k.X.Bytes()), Y: enc(k.Y.Bytes())}
+	}
+	return jsonWebKey{}
+}
+
+func (s *testSigner) sign(t *testing.T, claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := enc(jwtHeader{Alg: s.alg, Kid: s.kid, Typ: "JWT"}) + "." + enc(claims)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	var signature []byte
+	switch k := s.key.(type) {
+	case *rsa.PrivateKey:
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = sig
+	case *ecdsa.PrivateKey:
+		r, sv, err := ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), sv.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func keySetJSON(t *testing.T, signers ...*testSigner) []byte {
+	var set jsonWebKeySet
+	for _, s := range signers {
+		set.Keys = append(set.Keys, s.jwk())
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestOIDCVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigner := &testSigner{kid: "rsa", alg: "RS256", key: rsaKey}
+	ecSigner := &testSigner{kid: "ec", alg: "ES256", key: ecKey}
+	unknownSigner := &testSigner{kid: "rsa", alg: "RS256", key: ecKey}
+
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(path, keySetJSON(t, rsaSigner, ecSigner), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewOIDCVerifier(OIDCConfig{
+		Issuer:    "https://issuer.test",
+		Audiences: []string{"api"},
+		ClockSkew: time.Minute,
+		Keys:      NewJWKSFromFile(path, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":                            "https://issuer.test",
+			"aud":                            []string{"other", "api"},
+			"sub":                            "machine-1",
+			"exp":                            now.Add(time.Hour).Unix(),
+			"iat":                            now.Unix(),
+			apimeta.ClaimKeyDefaultWorkspace: "acme.prod",
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantUID string
+		wantErr bool
+	}{
+		{name: "RS256", token: rsaSigner.sign(t, claims(nil)), wantUID: "machine-1"},
+		{name: "ES256", token: ecSigner.sign(t, claims(nil)), wantUID: "machine-1"},
+		{name: "expired within skew", token: rsaSigner.sign(t, claims(map[string]interface{}{"exp": now.Add(-30 * time.Second).Unix()})), wantUID: "machine-1"},
+		{name: "expired", token: rsaSigner.sign(t, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})), wantErr: true},
+		{name: "not yet valid", token: rsaSigner.sign(t, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), wantErr: true},
+		{name: "wrong issuer", token: rsaSigner.sign(t, claims(map[string]interface{}{"iss": "https://evil.test"})), wantErr: true},
+		{name: "wrong audience", token: rsaSigner.sign(t, claims(map[string]interface{}{"aud": "other"})), wantErr: true},
+		{name: "bad signature", token: unknownSigner.sign(t, claims(nil)), wantErr: true},
+		{name: "malformed", token: "not-a-token", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := verifier.VerifyIDToken(context.Background(), tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyIDToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if token.UID != tt.wantUID {
+				t.Errorf("VerifyIDToken() UID = %q, want %q", token.UID, tt.wantUID)
+			}
+			if token.Claims[apimeta.ClaimKeyDefaultWorkspace] != "acme.prod" {
+				t.Errorf("VerifyIDToken() default workspace = %v", token.Claims[apimeta.ClaimKeyDefaultWorkspace])
+			}
+		})
+	}
+}
+
+func TestOIDCEnforcement(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &testSigner{kid: "rsa", alg: "RS256", key: key}
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(path, keySetJSON(t, signer), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oidc, err := NewOIDCVerifier(OIDCConfig{
+		Issuer:    "https://issuer.test",
+		Audiences: []string{"api"},
+		Keys:      NewJWKSFromFile(path, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The subjects of OIDC tokens have no record in the user resolver
+	i := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+	WithEnforcement()(i)
+	WithSchemeVerifier(apictx.SchemeBearer, NewChainVerifier(i.verifier, oidc))(i)
+	token := signer.sign(t, map[string]interface{}{
+		"iss":                            "https://issuer.test",
+		"aud":                            "api",
+		"sub":                            "machine-1",
+		"exp":                            time.Now().Add(time.Hour).Unix(),
+		"email":                          "machine-1@ci.test",
+		apimeta.ClaimKeyDefaultWorkspace: "acme.prod",
+	})
+
+	tests := []struct {
+		name      string
+		token     string
+		wantUID   string
+		wantEmail string
+	}{
+		{name: "oidc subject", token: token, wantUID: "machine-1", wantEmail: "machine-1@ci.test"},
+		{name: "firebase user", token: "token-alice", wantUID: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+tt.token, apimeta.HeaderWorkspace, "acme.prod"))
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+			called := false
+			_, err := i.UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				record, err := apictx.UserRecordFromContext(ctx)
+				if err != nil || record.UID != tt.wantUID || record.Email != tt.wantEmail {
+					t.Errorf("UserRecordFromContext() = %v, %v", record, err)
+				}
+				return nil, nil
+			})
+			if err != nil || !called {
+				t.Errorf("UnaryServerInterceptor() error = %v, handler called %v", err, called)
+			}
+		})
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSigner := &testSigner{kid: "old", alg: "RS256", key: oldKey}
+	newSigner := &testSigner{kid: "new", alg: "RS256", key: newKey}
+
+	var mu sync.Mutex
+	current := keySetJSON(t, oldSigner)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(current)
+	}))
+	defer server.Close()
+
+	keys := NewJWKSFromURL(server.URL, server.Client(), time.Hour)
+	clock := time.Now()
+	keys.now = func() time.Time { return clock }
+
+	if _, err := keys.Key(context.Background(), "old"); err != nil {
+		t.Fatalf("Key(old) error = %v", err)
+	}
+
+	mu.Lock()
+	current = keySetJSON(t, newSigner)
+	mu.Unlock()
+
+	// Reloads for unknown keys are rate limited
+	if _, err := keys.Key(context.Background(), "new"); err == nil {
+		t.Errorf("Key(new) expected error before the minimum refresh interval")
+	}
+	clock = clock.Add(minJWKSRefresh + time.Second)
+	if _, err := keys.Key(context.Background(), "new"); err != nil {
+		t.Errorf("Key(new) error = %v after rotation", err)
+	}
+	if _, err := keys.Key(context.Background(), "old"); err == nil {
+		t.Errorf("Key(old) expected error after rotation")
+	}
+}
+
+func TestJWKSFailures(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &testSigner{kid: "rsa", alg: "RS256", key: key}
+	var set jsonWebKeySet
+	set.Keys = append(set.Keys, jsonWebKey{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: "AA"}, signer.jwk())
+	valid, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var fetches int
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(valid)
+	}))
+	defer server.Close()
+
+	keys := NewJWKSFromURL(server.URL, server.Client(), time.Hour)
+	clock := time.Now()
+	keys.now = func() time.Time { return clock }
+
+	// Failed reloads are retried at most once per minimum refresh interval
+	for n := 0; n < 3; n++ {
+		if _, err := keys.Key(context.Background(), "rsa"); err == nil {
+			t.Errorf("Key(rsa) expected error while the endpoint fails")
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("fetches = %d, want 1", fetches)
+	}
+
+	// Keys of unsupported types are skipped rather than failing the key set
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	clock = clock.Add(minJWKSRefresh + time.Second)
+	if _, err := keys.Key(context.Background(), "rsa"); err != nil {
+		t.Errorf("Key(rsa) error = %v", err)
+	}
+	if _, err := keys.Key(context.Background(), "ed"); err == nil {
+		t.Errorf("Key(ed) expected error for an unsupported key type")
+	}
+	if fetches != 2 {
+		t.Errorf("fetches = %d, want 2", fetches)
+	}
+}
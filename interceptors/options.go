@@ -0,0 +1,136 @@
+package interceptors
+
+import (
+	"path"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// Option configures the state interceptors
+type Option func(*interceptor)
+
+// WithEnforcement rejects requests which fail authentication or workspace resolution with the resulting status
+// rather than passing them to the handler without state
+func WithEnforcement() Option {
+	return func(i *interceptor) {
+		i.enforce = true
+	}
+}
+
+// WithAllowlist skips authentication for the supplied methods, e.g. health checks and reflection.
+// Entries are full method names such as /grpc.health.v1.Health/Check or globs such as /grpc.health.v1.Health/*
+func WithAllowlist(methods ...string) Option {
+	return func(i *interceptor) {
+		i.allowlist = append(i.allowlist, methods...)
+	}
+}
+
+// WithMembershipVerifier rejects requests for workspaces the authenticated user is not a member of,
+// the workspace state is not set for such requests
+func WithMembershipVerifier(verifier MembershipVerifier) Option {
+	return func(i *interceptor) {
+		i.membership = verifier
+	}
+}
+
+// WithNamespaceResolver replaces the default namespace lookup, e.g. with a CachedNamespaceResolver
+func WithNamespaceResolver(resolver NamespaceResolver) Option {
+	return func(i *interceptor) {
+		i.namespaces = resolver
+	}
+}
+
+// WithLazyUserRecord defers retrieving the user record until UserRecordFromContext is called.
+// Failures retrieving the record are then no longer reported when enforcing.
+func WithLazyUserRecord() Option {
+	return func(i *interceptor) {
+		i.lazyUserRecord = true
+	}
+}
+
+// WithLazyResolution defers verifying the token, retrieving the user record and resolving the workspace
+// until the handler first requests each value, e.g. through UserFromContext or NamespaceFromContext.
+// Enforcement requires the state up front, so this has no effect combined with WithEnforcement.
+func WithLazyResolution() Option {
+	return func(i *interceptor) {
+		i.lazy = true
+	}
+}
+
+// WithServiceAuthenticators accepts requests from trusted services when no user credentials are supplied, or when
+// the credentials are rejected by the verifier but accepted by a token service authenticator. Services may scope a
+// request to the workspaces allowed by WithServiceWorkspaceVerifier with the x-ddev-workspace header, and act on behalf
+// of a user with the x-ddev-on-behalf-of header when allowed by NewDelegatingServiceAuthenticator.
+func WithServiceAuthenticators(authenticators ...ServiceAuthenticator) Option {
+	return func(i *interceptor) {
+		i.services = append(i.services, authenticators...)
+	}
+}
+
+// WithServiceWorkspaceVerifier allows services to scope requests to the workspaces accepted by the verifier, e.g.
+// ServiceWorkspaces. Service requests scoped to a workspace are denied without it.
+func WithServiceWorkspaceVerifier(verifier ServiceWorkspaceVerifier) Option {
+	return func(i *interceptor) {
+		i.serviceWorkspaces = verifier
+	}
+}
+
+// WithImpersonation allows users holding the claim, apimeta.ClaimKeyAdmin when empty, to act as the user named
+// in the x-ddev-impersonate-user header. The hook, which may be nil, is called for every impersonation attempt.
+func WithImpersonation(claim string, hook ImpersonationHook) Option {
+	if claim == "" {
+		claim = apimeta.ClaimKeyAdmin
+	}
+	return func(i *interceptor) {
+		i.impersonation = &impersonation{
+			claim: claim,
+			hook:  hook,
+		}
+	}
+}
+
+// WithMetrics records the token verification and namespace lookup metrics
+func WithMetrics(metrics *Metrics) Option {
+	return func(i *interceptor) {
+		i.metrics = metrics
+	}
+}
+
+// WithTracerProvider records spans for verifying the token, retrieving the user record and resolving the workspace,
+// the global tracer provider is used by default
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(i *interceptor) {
+		i.tracer = tracerFrom(tp)
+	}
+}
+
+// WithSchemeVerifier verifies the credentials of an authorization scheme, e.g. "basic" for API keys. Bearer
+// tokens and x-auth-token values are verified by the interceptor verifier unless replaced.
+func WithSchemeVerifier(scheme string, verifier TokenVerifier) Option {
+	return func(i *interceptor) {
+		i.schemes[strings.ToLower(scheme)] = verifier
+	}
+}
+
+// WithLegacyAuthorization accepts authorization values without a scheme as bearer tokens. Deprecated clients
+// relying on it are counted by the legacy authorization metric.
+func WithLegacyAuthorization() Option {
+	return func(i *interceptor) {
+		i.legacyAuthorization = true
+	}
+}
+
+func (i *interceptor) allowed(method string) bool {
+	for _, pattern := range i.allowlist {
+		if pattern == method {
+			return true
+		}
+		if matched, err := path.Match(pattern, method); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	apierr "github.com/drud/api-common/errors"
+)
+
+// PanicHook receives the value recovered from a panicking handler and its stack, e.g. for crash reporting
+type PanicHook func(ctx context.Context, recovered interface{}, stack []byte)
+
+type recovery struct {
+	hook PanicHook
+}
+
+// NewRecoveryInterceptor converts handler panics into Internal errors logged through AbstractError, the hook may be nil.
+// Install it after the state interceptor so the request state is logged with the panic.
+func NewRecoveryInterceptor(hook PanicHook) StateInterceptors {
+	return &recovery{hook: hook}
+}
+
+func (r *recovery) recover(ctx context.Context, recovered interface{}) error {
+	stack := make([]byte, 64<<10)
+	stack = stack[:runtime.Stack(stack, false)]
+	if r.hook != nil {
+		r.hook(ctx, recovered, stack)
+	}
+	// Keyed on the recovered value, the stack differs by goroutine and would defeat sampling
+	return apierr.AbstractError(ctx, codes.Internal, "an internal error occured", apierr.WithStack(fmt.Errorf("panic: %v", recovered), stack))
+}
+
+func (r *recovery) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				resp, err = nil, r.recover(ctx, recovered)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func (r *recovery) StreamingServerInterceptor() grpc.StreamServerInterceptor {
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = r.recover(ss.Context(), recovered)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
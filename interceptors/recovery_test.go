@@ -0,0 +1,125 @@
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/klogr"
+
+	apictx "github.com/drud/api-common/context"
+	apierr "github.com/drud/api-common/errors"
+)
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testServerStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	var hookUser, hookStack string
+	var hookValue interface{}
+	recovery := NewRecoveryInterceptor(func(ctx context.Context, recovered interface{}, stack []byte) {
+		hookUser, _ = apictx.UserFromContext(ctx)
+		hookValue = recovered
+		hookStack = string(stack)
+	})
+	ctx := apictx.WithState(context.Background(), &apictx.RequestState{User: "alice"})
+
+	tests := []struct {
+		name   string
+		invoke func() error
+	}{
+		{
+			name: "unary",
+			invoke: func() error {
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					panic("boom")
+				}
+				resp, err := recovery.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, handler)
+				if resp != nil {
+					t.Errorf("UnaryServerInterceptor() resp = %v, want nil", resp)
+				}
+				return err
+			},
+		},
+		{
+			name: "streaming",
+			invoke: func() error {
+				handler := func(srv interface{}, ss grpc.ServerStream) error {
+					panic("boom")
+				}
+				return recovery.StreamingServerInterceptor()(nil, &testServerStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}, handler)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hookUser, hookValue, hookStack = "", nil, ""
+			err := tt.invoke()
+			if code := status.Code(err); code != codes.Internal {
+				t.Errorf("code = %v, want Internal (%v)", code, err)
+			}
+			if strings.Contains(status.Convert(err).Message(), "boom") {
+				t.Errorf("message = %q, want panic value hidden", status.Convert(err).Message())
+			}
+			if hookUser != "alice" || hookValue != "boom" || !strings.Contains(hookStack, "recovery_test.go") {
+				t.Errorf("hook = %q, %v, stack %q", hookUser, hookValue, hookStack)
+			}
+		})
+	}
+}
+
+func TestRecoverySampling(t *testing.T) {
+	var buf bytes.Buffer
+	apierr.SetLogger(apierr.NewJSONLogger(&buf))
+	defer apierr.SetLogger(klogr.New())
+	apierr.SetSampling(apierr.SamplingConfig{First: 1, Interval: time.Hour})
+	defer apierr.SetSampling(apierr.SamplingConfig{})
+
+	recovery := NewRecoveryInterceptor(nil)
+	ctx := apictx.WithState(context.Background(), &apictx.RequestState{Procedure: "/test.Service/Method"})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	var wg sync.WaitGroup
+	for n := 0; n < 3; n++ {
+		// Each panic happens on a goroutine of its own, as with concurrent requests
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recovery.UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				panic("boom")
+			})
+		}()
+	}
+	wg.Wait()
+
+	if lines := strings.Count(buf.String(), "\n"); lines != 1 {
+		t.Fatalf("logged %d lines, want 1: %s", lines, buf.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log entry %q: %v", buf.String(), err)
+	}
+	if entry["error"] != "panic: boom" {
+		t.Errorf("entry[error] = %v, want panic: boom", entry["error"])
+	}
+	if stack, _ := entry["stack"].(string); !strings.Contains(stack, "recovery_test.go") {
+		t.Errorf("entry[stack] = %q, want the panicking stack", stack)
+	}
+}
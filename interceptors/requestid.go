@@ -0,0 +1,45 @@
+package interceptors
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// maxRequestIDLength bounds the incoming request IDs which are honoured, longer IDs are replaced
+const maxRequestIDLength = 128
+
+// withRequestID stores the request ID supplied by the caller in the request state, generating one when
+// the caller supplied none or the supplied ID is unsuitable for logging
+func withRequestID(ctx context.Context) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(apimeta.HeaderRequestID); len(values) > 0 && validRequestID(values[0]) {
+			id = strings.TrimSpace(values[0])
+		}
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+	state := apictx.FromContext(ctx)
+	state.RequestID = id
+	return apictx.WithState(ctx, state)
+}
+
+func validRequestID(id string) bool {
+	id = strings.TrimSpace(id)
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package interceptors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		md       metadata.MD
+		want     string
+		generate bool
+	}{
+		{
+			name: "incoming id",
+			md:   metadata.Pairs(apimeta.HeaderRequestID, " req-1 "),
+			want: "req-1",
+		},
+		{
+			name:     "no id",
+			md:       metadata.MD{},
+			generate: true,
+		},
+		{
+			name:     "id with control characters",
+			md:       metadata.Pairs(apimeta.HeaderRequestID, "req\n1"),
+			generate: true,
+		},
+		{
+			name:     "id too long",
+			md:       metadata.Pairs(apimeta.HeaderRequestID, strings.Repeat("a", maxRequestIDLength+1)),
+			generate: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := withRequestID(metadata.NewIncomingContext(context.Background(), tt.md))
+			got, err := apictx.RequestIDFromContext(ctx)
+			if err != nil {
+				t.Fatalf("RequestIDFromContext() error = %v", err)
+			}
+			if tt.generate {
+				if _, err := uuid.Parse(got); err != nil {
+					t.Errorf("RequestIDFromContext() = %q, want generated UUID", got)
+				}
+			} else if got != tt.want {
+				t.Errorf("RequestIDFromContext() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
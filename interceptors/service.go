@@ -0,0 +1,242 @@
+package interceptors
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	fbauth "firebase.google.com/go/auth"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"k8s.io/klog"
+
+	apictx "github.com/drud/api-common/context"
+	apierr "github.com/drud/api-common/errors"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+// ServiceAuthenticator identifies trusted internal callers, e.g. controllers and cron jobs, which do not hold a user token
+type ServiceAuthenticator interface {
+	AuthenticateService(ctx context.Context, md metadata.MD) (string, error)
+}
+
+type peerAuthenticator struct {
+	trusted map[string]bool
+}
+
+// NewPeerServiceAuthenticator authenticates services by the verified client certificate of an mTLS connection.
+// The certificate URI SANs (e.g. SPIFFE IDs), DNS SANs and common name are matched against the trusted identities.
+func NewPeerServiceAuthenticator(trusted ...string) ServiceAuthenticator {
+	p := &peerAuthenticator{trusted: make(map[string]bool, len(trusted))}
+	for _, identity := range trusted {
+		p.trusted[identity] = true
+	}
+	return p
+}
+
+func (p *peerAuthenticator) AuthenticateService(ctx context.Context, md metadata.MD) (string, error) {
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("no peer for request")
+	}
+	tlsInfo, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return "", fmt.Errorf("peer is not using TLS")
+	}
+	if len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", fmt.Errorf("peer presented no verified client certificate")
+	}
+	for _, identity := range certificateIdentities(tlsInfo.State.VerifiedChains[0][0]) {
+		if p.trusted[identity] {
+			return identity, nil
+		}
+	}
+	return "", fmt.Errorf("peer certificate is not trusted")
+}
+
+func certificateIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	identities = append(identities, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	return identities
+}
+
+type tokenAuthenticator struct {
+	verifier TokenVerifier
+	trusted  map[string]bool
+}
+
+// NewTokenServiceAuthenticator authenticates services by a signed service token supplied in place of the user token,
+// e.g. verified by an OIDC verifier configured for the service token issuer. When services are supplied only tokens
+// for those subjects are accepted.
+func NewTokenServiceAuthenticator(verifier TokenVerifier, services ...string) ServiceAuthenticator {
+	t := &tokenAuthenticator{verifier: verifier}
+	if len(services) > 0 {
+		t.trusted = make(map[string]bool, len(services))
+		for _, service := range services {
+			t.trusted[service] = true
+		}
+	}
+	return t
+}
+
+// contextKeyLegacyAuthorization marks the requests whose authorization without a scheme was accepted by the state
+// interceptor, see WithLegacyAuthorization
+type contextKeyLegacyAuthorization struct{}
+
+func (t *tokenAuthenticator) AuthenticateService(ctx context.Context, md metadata.MD) (string, error) {
+	creds, err := apictx.CredentialsFromMeta(md)
+	if err != nil {
+		return "", err
+	}
+	if creds.Scheme != apictx.SchemeBearer {
+		return "", fmt.Errorf("unsupported authorization scheme %s for service tokens", creds.Scheme)
+	}
+	if creds.Legacy && ctx.Value(contextKeyLegacyAuthorization{}) == nil {
+		return "", fmt.Errorf("authorization supplied without a scheme")
+	}
+	token, err := t.verifier.VerifyIDToken(ctx, creds.Token)
+	if err != nil {
+		return "", err
+	}
+	if t.trusted != nil && !t.trusted[token.UID] {
+		return "", fmt.Errorf("service %s is not trusted", token.UID)
+	}
+	return token.UID, nil
+}
+
+// credentialAuthenticator is implemented by the service authenticators verifying the credentials supplied in place
+// of a user token, rather than an identity established outside the request such as the peer certificate
+type credentialAuthenticator interface {
+	verifiesCredentials() bool
+}
+
+func (t *tokenAuthenticator) verifiesCredentials() bool {
+	return true
+}
+
+type delegatingAuthenticator struct {
+	ServiceAuthenticator
+}
+
+// NewDelegatingServiceAuthenticator allows the services identified by the authenticator to act on behalf of a user
+// with the x-ddev-on-behalf-of header, the header is rejected from other services
+func NewDelegatingServiceAuthenticator(authenticator ServiceAuthenticator) ServiceAuthenticator {
+	return &delegatingAuthenticator{authenticator}
+}
+
+func (d *delegatingAuthenticator) verifiesCredentials() bool {
+	return verifiesCredentials(d.ServiceAuthenticator)
+}
+
+func verifiesCredentials(authenticator ServiceAuthenticator) bool {
+	c, ok := authenticator.(credentialAuthenticator)
+	return ok && c.verifiesCredentials()
+}
+
+// ServiceWorkspaceVerifier confirms a service may act in the workspace a request resolved to, returning a
+// PermissionDenied status when it may not
+type ServiceWorkspaceVerifier interface {
+	VerifyServiceWorkspace(ctx context.Context, service string, namespace string) error
+}
+
+// ServiceWorkspaces is a ServiceWorkspaceVerifier allowing each service the listed namespaces, "*" allows a service
+// every namespace
+type ServiceWorkspaces map[string][]string
+
+func (s ServiceWorkspaces) VerifyServiceWorkspace(ctx context.Context, service string, namespace string) error {
+	for _, allowed := range s[service] {
+		if allowed == "*" || allowed == namespace {
+			return nil
+		}
+	}
+	return apierr.WorkspaceAccessDenied.New(ctx, fmt.Errorf("service %s is not allowed workspace %s", service, namespace))
+}
+
+func (i *interceptor) verifyServiceWorkspace(ctx context.Context, namespace string) error {
+	service, err := apictx.ServiceFromContext(ctx)
+	if err != nil {
+		return apierr.WorkspaceAccessDenied.New(ctx, err)
+	}
+	if i.serviceWorkspaces == nil {
+		return apierr.WorkspaceAccessDenied.New(ctx, fmt.Errorf("service %s is not allowed any workspaces", service))
+	}
+	return i.serviceWorkspaces.VerifyServiceWorkspace(ctx, service, namespace)
+}
+
+// principal is the authenticated caller of a request, either a user or a service optionally acting on behalf of a user
+type principal struct {
+	token   *fbauth.Token
+	bearer  string
+	user    string
+	actor   string
+	service string
+}
+
+func (i *interceptor) authenticate(ctx context.Context, md metadata.MD) (*principal, error) {
+	start := time.Now()
+	spanCtx, span := i.tracer.Start(ctx, "VerifyToken")
+	token, creds, kind, err := i.verifyCredentials(spanCtx, md)
+	endSpan(span, err)
+	if i.metrics != nil {
+		i.metrics.observeToken(start, kind)
+	}
+	if err == nil {
+		p := &principal{token: token, user: token.UID}
+		if creds.Scheme == apictx.SchemeBearer {
+			// Only bearer tokens are forwarded to other services
+			p.bearer = creds.Token
+		}
+		if i.impersonation != nil {
+			if err := i.impersonate(ctx, md, p); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	}
+	serviceCtx := ctx
+	if creds != nil && creds.Legacy {
+		// Only reached when the legacy authorization was accepted, and counted, by verifyCredentials
+		serviceCtx = context.WithValue(ctx, contextKeyLegacyAuthorization{}, true)
+	}
+	for _, authenticator := range i.services {
+		if kind != apierr.TokenMissing && !(kind == apierr.TokenInvalid && verifiesCredentials(authenticator)) {
+			// Services do not stand in for rejected user credentials, unless the credentials are their own
+			continue
+		}
+		service, serviceErr := authenticator.AuthenticateService(serviceCtx, md)
+		if serviceErr != nil {
+			if debug {
+				klog.Infof("AuthenticateService error: %v", serviceErr)
+			}
+			continue
+		}
+		p := &principal{service: service}
+		if onBehalfOf := md.Get(apimeta.HeaderOnBehalfOf); len(onBehalfOf) > 0 {
+			if _, ok := authenticator.(*delegatingAuthenticator); !ok {
+				return nil, apierr.DelegationDenied.New(ctx, fmt.Errorf("service %s may not act on behalf of users", service))
+			}
+			p.user = strings.TrimSpace(onBehalfOf[0])
+		}
+		return p, nil
+	}
+	if kind == apierr.TokenMissing {
+		// Anonymous requests are routine when authentication is not enforced, so are not logged
+		return nil, kind.Status(ctx, err)
+	}
+	return nil, kind.New(ctx, err)
+}
+
+// isService reports whether the request was made by a service principal
+func isService(ctx context.Context) bool {
+	_, err := apictx.ServiceFromContext(ctx)
+	return err == nil
+}
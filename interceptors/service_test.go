@@ -0,0 +1,191 @@
+package interceptors
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"testing"
+
+	fbauth "firebase.google.com/go/auth"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func peerContext(ctx context.Context, uri string) context.Context {
+	id, _ := url.Parse(uri)
+	return peer.NewContext(ctx, &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{{URIs: []*url.URL{id}}}},
+			},
+		},
+	})
+}
+
+func TestServiceAuthentication(t *testing.T) {
+	labelled := testNamespace("ns-acme-prod", "acme", "prod")
+	labelled.Labels[apimeta.LabelKeyMemberPrefix+"alice"] = "true"
+	i := testInterceptor(labelled, testNamespace("ns-globex-prod", "globex", "prod"))
+	WithEnforcement()(i)
+	WithMembershipVerifier(NewNamespaceMembership(i.crClient))(i)
+	serviceTokens := StaticTokenVerifier{
+		"token-billing": &fbauth.Token{UID: "billing-controller"},
+		"token-rogue":   &fbauth.Token{UID: "rogue"},
+	}
+	WithServiceAuthenticators(
+		NewPeerServiceAuthenticator("spiffe://ddev.live/cron", "spiffe://ddev.live/reports"),
+		NewDelegatingServiceAuthenticator(NewTokenServiceAuthenticator(serviceTokens, "billing-controller")),
+	)(i)
+	WithServiceWorkspaceVerifier(ServiceWorkspaces{
+		"billing-controller":      {"ns-acme-prod"},
+		"spiffe://ddev.live/cron": {"*"},
+	})(i)
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		md          metadata.MD
+		wantCode    codes.Code
+		wantService string
+		wantUser    string
+	}{
+		{
+			name:        "service token",
+			ctx:         context.Background(),
+			md:          metadata.Pairs(apimeta.HeaderAuthToken, "token-billing", apimeta.HeaderWorkspace, "acme.prod"),
+			wantService: "billing-controller",
+		},
+		{
+			name:        "service token on behalf of user",
+			ctx:         context.Background(),
+			md:          metadata.Pairs(apimeta.HeaderAuthToken, "token-billing", apimeta.HeaderOnBehalfOf, "bob", apimeta.HeaderWorkspace, "acme.prod"),
+			wantService: "billing-controller",
+			wantUser:    "bob",
+		},
+		{
+			name:     "service token outside its workspaces",
+			ctx:      context.Background(),
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-billing", apimeta.HeaderWorkspace, "globex.prod"),
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "untrusted service token",
+			ctx:      context.Background(),
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-rogue", apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:        "mTLS peer",
+			ctx:         peerContext(context.Background(), "spiffe://ddev.live/cron"),
+			md:          metadata.Pairs(apimeta.HeaderWorkspace, "acme.prod"),
+			wantService: "spiffe://ddev.live/cron",
+		},
+		{
+			name:     "mTLS peer on behalf of user",
+			ctx:      peerContext(context.Background(), "spiffe://ddev.live/cron"),
+			md:       metadata.Pairs(apimeta.HeaderOnBehalfOf, "bob", apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "mTLS peer with invalid user token",
+			ctx:      peerContext(context.Background(), "spiffe://ddev.live/cron"),
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-expired", apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "mTLS peer with malformed authorization",
+			ctx:      peerContext(context.Background(), "spiffe://ddev.live/cron"),
+			md:       metadata.Pairs("authorization", "Bearer a b", apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "mTLS peer without workspaces",
+			ctx:      peerContext(context.Background(), "spiffe://ddev.live/reports"),
+			md:       metadata.Pairs(apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "untrusted mTLS peer",
+			ctx:      peerContext(context.Background(), "spiffe://ddev.live/other"),
+			md:       metadata.Pairs(apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "user token preferred",
+			ctx:      peerContext(context.Background(), "spiffe://ddev.live/cron"),
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.prod"),
+			wantUser: "alice",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(tt.ctx, tt.md)
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				service, _ := apictx.ServiceFromContext(ctx)
+				if service != tt.wantService {
+					t.Errorf("ServiceFromContext() = %q, want %q", service, tt.wantService)
+				}
+				user, _ := apictx.UserFromContext(ctx)
+				if user != tt.wantUser {
+					t.Errorf("UserFromContext() = %q, want %q", user, tt.wantUser)
+				}
+				if ns, err := apictx.NamespaceFromContext(ctx); err != nil || ns != "ns-acme-prod" {
+					t.Errorf("NamespaceFromContext() = %q, %v", ns, err)
+				}
+				return nil, nil
+			}
+			_, err := i.UnaryServerInterceptor()(ctx, nil, info, handler)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("UnaryServerInterceptor() code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestServiceTokenLegacyAuthorization(t *testing.T) {
+	serviceTokens := StaticTokenVerifier{"token-billing": &fbauth.Token{UID: "billing-controller"}}
+	md := metadata.Pairs("authorization", "token-billing", apimeta.HeaderWorkspace, "acme.prod")
+	if _, err := NewTokenServiceAuthenticator(serviceTokens).AuthenticateService(context.Background(), md); err == nil {
+		t.Errorf("AuthenticateService() accepted authorization without a scheme")
+	}
+
+	for _, legacy := range []bool{false, true} {
+		metrics := NewMetrics(MetricsConfig{})
+		i := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+		WithEnforcement()(i)
+		WithMetrics(metrics)(i)
+		WithServiceAuthenticators(NewTokenServiceAuthenticator(serviceTokens))(i)
+		WithServiceWorkspaceVerifier(ServiceWorkspaces{"billing-controller": {"ns-acme-prod"}})(i)
+		if legacy {
+			WithLegacyAuthorization()(i)
+		}
+
+		var service string
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		_, err := i.UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			service, _ = apictx.ServiceFromContext(ctx)
+			return nil, nil
+		})
+		if legacy && (err != nil || service != "billing-controller") {
+			t.Errorf("legacy accepted: service = %q, %v", service, err)
+		}
+		if !legacy && status.Code(err) != codes.Unauthenticated {
+			t.Errorf("legacy rejected: error = %v, want Unauthenticated", err)
+		}
+		if got := testutil.ToFloat64(metrics.legacyAuthorization.WithLabelValues(fmt.Sprint(legacy))); got != 1 {
+			t.Errorf("legacy authorization accepted=%v = %v, want 1", legacy, got)
+		}
+	}
+}
@@ -7,13 +7,9 @@ import (
 	"strings"
 
 	fbauth "firebase.google.com/go/auth"
-	"google.golang.org/genproto/googleapis/rpc/code"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
-	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -32,83 +28,173 @@ func init() {
 	}
 }
 
-func setBearerContext(ctx context.Context, md metadata.MD, firebaseClient *fbauth.Client) (context.Context, error) {
-	bearer, err := apictx.AuthTokenFromMeta(md)
+// verifyCredentials verifies the user credentials of the request with the verifier of their scheme,
+// failures are returned with the catalogue kind describing them and the credentials when they were accepted for
+// verification
+func (i *interceptor) verifyCredentials(ctx context.Context, md metadata.MD) (*fbauth.Token, *apictx.Credentials, *apierr.Kind, error) {
+	creds, err := apictx.CredentialsFromMeta(md)
+	if err == apictx.ErrNoCredentials {
+		return nil, nil, apierr.TokenMissing, err
+	}
 	if err != nil {
-		return ctx, status.Errorf(codes.Unauthenticated, "error retrieving authorization state: %v", err)
+		return nil, nil, apierr.CredentialsMalformed, err
+	}
+	if creds.Legacy {
+		if i.metrics != nil {
+			i.metrics.legacyAuthorization.WithLabelValues(fmt.Sprint(i.legacyAuthorization)).Inc()
+		}
+		if !i.legacyAuthorization {
+			return nil, nil, apierr.CredentialsMalformed, fmt.Errorf("authorization supplied without a scheme")
+		}
 	}
 
-	token, err := firebaseClient.VerifyIDToken(context.Background(), bearer)
+	verifier, ok := i.schemes[creds.Scheme]
+	if !ok {
+		return nil, nil, apierr.SchemeUnsupported, fmt.Errorf("no verifier for the %s scheme", creds.Scheme)
+	}
+	token, err := verifier.VerifyIDToken(ctx, creds.Token)
 	if err != nil {
-		return ctx, status.Errorf(codes.Unauthenticated, "error validiating token: %v", err)
+		return nil, creds, apierr.TokenInvalid, err
+	}
+	return token, creds, nil, nil
+}
+
+func (i *interceptor) setPrincipalContext(ctx context.Context, md metadata.MD) (context.Context, error) {
+	p, err := i.authenticate(ctx, md)
+	if err != nil {
+		return ctx, err
 	}
 	// Save state provided by the requests token
-	ctx = context.WithValue(ctx, apictx.ContextKeyToken{}, token)
-	ctx = context.WithValue(ctx, apictx.ContextKeyUser{}, token.UID)
+	state := apictx.FromContext(ctx)
+	state.Token = p.token
+	state.Bearer = p.bearer
+	state.User = p.user
+	state.Actor = p.actor
+	state.Service = p.service
+	ctx = apictx.WithState(ctx, state)
+	if p.user == "" {
+		// Services not acting on behalf of a user have no user record
+		return ctx, nil
+	}
+	if i.lazyUserRecord {
+		// Defer retrieving the record until a handler asks for it
+		loaderCtx := ctx
+		state.SetLazy(apictx.ContextKeyUserRecord{}, apictx.NewLazy(func() (interface{}, error) {
+			return i.getUserRecord(loaderCtx, p.user)
+		}))
+		return ctx, nil
+	}
 	// if we can store the most up to date record for the user in this token
-	record, err := firebaseClient.GetUser(ctx, token.UID)
+	record, err := i.getUserRecord(ctx, p.user)
 	if err != nil {
-		return ctx, status.Errorf(codes.Code(code.Code_UNAUTHENTICATED), "error retreiving user record: %v", err)
+		return ctx, err
 	}
-	ctx = context.WithValue(ctx, apictx.ContextKeyUserRecord{}, record)
+	state.UserRecord = record
 	return ctx, nil
 }
 
-func setWorkspaceContext(ctx context.Context, md metadata.MD, crClient client.Client) (context.Context, error) {
+func (i *interceptor) getUserRecord(ctx context.Context, uid string) (*fbauth.UserRecord, error) {
+	if token, err := apictx.AuthTokenFromContext(ctx); err == nil {
+		// OIDC subjects have no record for the resolver to retrieve
+		if record, ok := userRecordFromClaims(token, uid); ok {
+			return record, nil
+		}
+	}
+	ctx, span := i.tracer.Start(ctx, "GetUserRecord")
+	record, err := i.resolver.GetUser(ctx, uid)
+	if err != nil {
+		err = apierr.UserNotFound.New(ctx, err)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// workspaceState is the workspace scope derived for a request
+type workspaceState struct {
+	workspace    string
+	qualified    string
+	subscription string
+	namespace    string
+}
 
+// resolveWorkspace derives the workspace scope of the request, on error the state determined so far is returned
+func resolveWorkspace(ctx context.Context, md metadata.MD, resolver NamespaceResolver) (*workspaceState, error) {
+	state := &workspaceState{}
 	ws, err := apictx.WorkspaceFromMeta(md)
 	if err != nil {
 		if token, err := apictx.AuthTokenFromContext(ctx); err == nil {
 			iface, ok := token.Claims[apimeta.ClaimKeyDefaultWorkspace]
 			if !ok {
-				return ctx, status.Errorf(codes.Internal, "unable to determine workspace for request: %v", err)
+				return state, apierr.WorkspaceUnresolved.Status(ctx, err)
 			}
 			if defaultWS, ok := iface.(string); ok {
 				ws = defaultWS
 			}
 		} else {
-			return ctx, status.Errorf(codes.Internal, "unable to determine workspace for request: %v", err)
+			return state, apierr.WorkspaceUnresolved.Status(ctx, err)
 		}
 	}
-	ctx = context.WithValue(ctx, apictx.ContextKeyWorkspace{}, ws)
+	state.workspace = ws
 	wsSplit := strings.Split(ws, ".")
 	if len(wsSplit) > 1 {
-		subscription := wsSplit[0]
-		ctx = context.WithValue(ctx, apictx.ContextKeySubscription{}, subscription)
-		workspace := wsSplit[1]
-		ctx = context.WithValue(ctx, apictx.ContextKeyWorkspace{}, workspace)
-		ctx = context.WithValue(ctx, apictx.ContextKeyQualifiedWorkspace{}, ws)
-
-		selector := labels.NewSelector()
-		displayReqs, err := labels.ParseToRequirements(fmt.Sprintf("ddev.live/displayname==%s", workspace))
-		if err != nil {
-			return ctx, apierr.AbstractError(ctx, codes.Internal, "unable to determine workspace for request", err)
-		}
+		state.subscription = wsSplit[0]
+		state.workspace = wsSplit[1]
+		state.qualified = ws
 
-		subscriptionReqs, err := labels.ParseToRequirements(fmt.Sprintf("ddev.live/subscriptionstub==%s", subscription))
+		namespace, err := resolver.ResolveNamespace(ctx, state.subscription, state.workspace)
 		if err != nil {
-			return ctx, apierr.AbstractError(ctx, codes.Internal, "unable to determine workspace for request", err)
+			return state, err
 		}
-		selector = selector.Add(displayReqs...)
-		selector = selector.Add(subscriptionReqs...)
+		state.namespace = namespace
+	} else {
+		state.namespace = ws
+	}
+	return state, nil
+}
 
-		var namespaceList v1.NamespaceList
-		if err := crClient.List(ctx, &namespaceList, &client.ListOptions{
-			LabelSelector: selector,
-		}); err != nil {
-			return ctx, apierr.AbstractError(ctx, codes.Internal, "an internal error occured retrieving workspaces", err)
+func (w *workspaceState) apply(ctx context.Context) context.Context {
+	state := apictx.FromContext(ctx)
+	state.Workspace = w.workspace
+	state.QualifiedWorkspace = w.qualified
+	state.Subscription = w.subscription
+	state.Namespace = w.namespace
+	return apictx.WithState(ctx, state)
+}
+
+func (i *interceptor) resolveWorkspace(ctx context.Context, md metadata.MD) (state *workspaceState, err error) {
+	ctx, span := i.tracer.Start(ctx, "ResolveWorkspace")
+	defer func() {
+		endSpan(span, err)
+	}()
+	state, err = resolveWorkspace(ctx, md, i.namespaces)
+	if debug && err != nil {
+		klog.Infof("resolveWorkspace error: %v", err)
+	}
+	if err == nil && isService(ctx) {
+		// Services are only trusted with the workspaces they are allowed
+		if err := i.verifyServiceWorkspace(ctx, state.namespace); err != nil {
+			return &workspaceState{}, err
 		}
-		if len(namespaceList.Items) > 1 {
-			return ctx, status.Errorf(codes.NotFound, "ambiguous workspace for request")
+	}
+	if err == nil && !isService(ctx) {
+		// Tokens limited to workspaces, e.g. scoped API keys, are denied the workspaces outside their scope
+		if err := verifyScope(ctx, state); err != nil {
+			return &workspaceState{}, err
 		}
-		if len(namespaceList.Items) == 0 {
-			return ctx, status.Errorf(codes.NotFound, "no valid workspace found for request")
+	}
+	if err == nil && i.membership != nil && !isService(ctx) {
+		// Only retain the workspace state once the user has been confirmed as a member
+		if err := i.verifyMembership(ctx, state.namespace); err != nil {
+			if debug {
+				klog.Infof("verifyMembership error: %v", err)
+			}
+			return &workspaceState{}, err
 		}
-		ctx = context.WithValue(ctx, apictx.ContextKeyNamespace{}, namespaceList.Items[0].Name)
-	} else {
-		ctx = context.WithValue(ctx, apictx.ContextKeyNamespace{}, ws)
 	}
-	return ctx, nil
+	return state, err
 }
 
 /*
@@ -118,12 +204,11 @@ This compilation unit sets state carried with over the lifetime of the context
 //TODO: This compilation unit can be shared among all API servers
 //TODO: Requests can be mutated by an upstream service by something such as an ambassador plugin
 
-func getStatefulContext(ctx context.Context, firebaseClient *fbauth.Client, crClient client.Client) (context.Context, error) {
+func (i *interceptor) getStatefulContext(ctx context.Context) (context.Context, error) {
 
-	var err error
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return ctx, status.Errorf(codes.InvalidArgument, "retrieving metadata failed")
+		return ctx, apierr.MetadataMissing.New(ctx, nil)
 	}
 	if debug {
 		klog.Infof("Metadata:")
@@ -131,16 +216,110 @@ func getStatefulContext(ctx context.Context, firebaseClient *fbauth.Client, crCl
 			klog.Infof("%s: %s", k, v)
 		}
 	}
-	ctx, err = setBearerContext(ctx, md, firebaseClient)
-	if debug && err != nil {
-		klog.Infof("setBearerContext error: %v", err)
+	ctx, bearerErr := i.setPrincipalContext(ctx, md)
+	if debug && bearerErr != nil {
+		klog.Infof("setPrincipalContext error: %v", bearerErr)
 	}
-	ctx, err = setWorkspaceContext(ctx, md, crClient)
-	if debug && err != nil {
-		klog.Infof("setBearerContext error: %v", err)
+	ws, err := i.resolveWorkspace(ctx, md)
+	ctx = ws.apply(ctx)
+	if bearerErr != nil {
+		return ctx, bearerErr
 	}
 
 	// Save the derived workspace for any downstream methods
+	return ctx, err
+}
+
+// getLazyContext stores resolvers for the request state which are evaluated the first time each value is requested
+func (i *interceptor) getLazyContext(ctx context.Context) context.Context {
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	state := apictx.FromContext(ctx)
+	ctx = apictx.WithState(ctx, state)
+	// The resolvers see a snapshot of the state excluding their own lazies, logging an error
+	// from within a resolver would otherwise wait on the resolver itself
+	snapshot := func() context.Context {
+		return apictx.WithState(ctx, apictx.FromContext(ctx))
+	}
+	principalCtx := snapshot()
+	caller := apictx.NewLazy(func() (interface{}, error) {
+		p, err := i.authenticate(principalCtx, md)
+		if err != nil {
+			if debug {
+				klog.Infof("authenticate error: %v", err)
+			}
+			return nil, err
+		}
+		return p, nil
+	})
+	principalField := func(get func(*principal) interface{}) *apictx.Lazy {
+		return apictx.NewLazy(func() (interface{}, error) {
+			iface, err := caller.Value()
+			if err != nil {
+				return nil, err
+			}
+			return get(iface.(*principal)), nil
+		})
+	}
+	state.SetLazy(apictx.ContextKeyToken{}, principalField(func(p *principal) interface{} { return p.token }))
+	state.SetLazy(apictx.ContextKeyBearer{}, principalField(func(p *principal) interface{} { return p.bearer }))
+	state.SetLazy(apictx.ContextKeyUser{}, principalField(func(p *principal) interface{} { return p.user }))
+	state.SetLazy(apictx.ContextKeyActor{}, principalField(func(p *principal) interface{} { return p.actor }))
+	state.SetLazy(apictx.ContextKeyService{}, principalField(func(p *principal) interface{} { return p.service }))
+	recordCtx := snapshot()
+	state.SetLazy(apictx.ContextKeyUserRecord{}, apictx.NewLazy(func() (interface{}, error) {
+		uid, err := apictx.UserFromContext(recordCtx)
+		if err != nil {
+			return nil, err
+		}
+		return i.getUserRecord(recordCtx, uid)
+	}))
+
+	wsCtx := snapshot()
+	ws := apictx.NewLazy(func() (interface{}, error) {
+		return i.resolveWorkspace(wsCtx, md)
+	})
+	field := func(get func(*workspaceState) string) *apictx.Lazy {
+		return apictx.NewLazy(func() (interface{}, error) {
+			// A partially resolved workspace still provides the fields determined before the failure
+			iface, _ := ws.Value()
+			if value := get(iface.(*workspaceState)); value != "" {
+				return value, nil
+			}
+			return nil, fmt.Errorf("workspace field not resolved")
+		})
+	}
+	state.SetLazy(apictx.ContextKeyWorkspace{}, field(func(w *workspaceState) string { return w.workspace }))
+	state.SetLazy(apictx.ContextKeyQualifiedWorkspace{}, field(func(w *workspaceState) string { return w.qualified }))
+	state.SetLazy(apictx.ContextKeySubscription{}, field(func(w *workspaceState) string { return w.subscription }))
+	state.SetLazy(apictx.ContextKeyNamespace{}, field(func(w *workspaceState) string { return w.namespace }))
+	return ctx
+}
+
+// withProcedure returns a context with the procedure set on the request state
+func withProcedure(ctx context.Context, procedure string) context.Context {
+	state := apictx.FromContext(ctx)
+	state.Procedure = procedure
+	return apictx.WithState(ctx, state)
+}
+
+// statefulContext derives the request state, only surfacing errors when enforcing
+func (i *interceptor) statefulContext(ctx context.Context, method string) (context.Context, error) {
+	if i.allowed(method) {
+		return ctx, nil
+	}
+	if i.lazy && !i.enforce {
+		return i.getLazyContext(ctx), nil
+	}
+	ctx, err := i.getStatefulContext(ctx)
+	setSpanAttributes(ctx)
+	if i.enforce {
+		return ctx, err
+	}
+	// Interceptor designed to extract and set state, however not error
 	return ctx, nil
 }
 
@@ -176,15 +355,55 @@ type StateInterceptors interface {
 }
 
 type interceptor struct {
-	firebaseClient *fbauth.Client
-	crClient       client.Client
+	verifier TokenVerifier
+	resolver UserResolver
+	crClient client.Client
+
+	enforce    bool
+	allowlist  []string
+	membership MembershipVerifier
+	namespaces NamespaceResolver
+
+	services          []ServiceAuthenticator
+	serviceWorkspaces ServiceWorkspaceVerifier
+	impersonation     *impersonation
+	metrics           *Metrics
+	tracer            trace.Tracer
+
+	schemes             map[string]TokenVerifier
+	legacyAuthorization bool
+	lazy                bool
+	lazyUserRecord      bool
+}
+
+func NewStateInterceptor(firebaseClient *fbauth.Client, crClient client.Client, opts ...Option) StateInterceptors {
+	return NewStateInterceptorWithVerifier(NewFirebaseVerifier(firebaseClient), NewFirebaseUserResolver(firebaseClient), crClient, opts...)
 }
 
-func NewStateInterceptor(firebaseClient *fbauth.Client, crClient client.Client) StateInterceptors {
-	return &interceptor{
-		firebaseClient: firebaseClient,
-		crClient:       crClient,
+// NewStateInterceptorWithVerifier creates state interceptors which authenticate requests against the supplied verifier and resolver
+func NewStateInterceptorWithVerifier(verifier TokenVerifier, resolver UserResolver, crClient client.Client, opts ...Option) StateInterceptors {
+	i := &interceptor{
+		verifier: verifier,
+		resolver: resolver,
+		crClient: crClient,
+		schemes:  make(map[string]TokenVerifier),
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	if _, ok := i.schemes[apictx.SchemeBearer]; !ok {
+		i.schemes[apictx.SchemeBearer] = verifier
+	}
+	if i.namespaces == nil {
+		i.namespaces = NewNamespaceResolver(crClient)
 	}
+	if i.tracer == nil {
+		i.tracer = tracerFrom(nil)
+	}
+	if i.metrics != nil {
+		i.namespaces = &instrumentedResolver{resolver: i.namespaces, metrics: i.metrics}
+	}
+	return i
 }
 
 func (i *interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
@@ -195,9 +414,16 @@ func (i *interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 			klog.Infof("Procedure Start: %s", info.FullMethod)
 			defer klog.Infof("Procedure End: %s", info.FullMethod)
 		}
-		ctx = context.WithValue(ctx, apictx.ContextKeyProcedure{}, info.FullMethod)
-		// Interceptor designed to extract and set state, however not error
-		ctx, _ = getStatefulContext(ctx, i.firebaseClient, i.crClient)
+		ctx = withProcedure(ctx, info.FullMethod)
+		ctx = withRequestID(ctx)
+		if id, err := apictx.RequestIDFromContext(ctx); err == nil {
+			// Fails when not called by a grpc server, e.g. in tests
+			_ = grpc.SetHeader(ctx, metadata.Pairs(apimeta.HeaderRequestID, id))
+		}
+		ctx, err := i.statefulContext(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
 		return handler(ctx, req)
 	}
 }
@@ -206,9 +432,15 @@ func (i *interceptor) StreamingServerInterceptor() grpc.StreamServerInterceptor
 
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		w := newStreamContextWrapper(ss)
-		ctx := context.WithValue(w.Context(), apictx.ContextKeyProcedure{}, info.FullMethod)
-		// Interceptor designed to extract and set state, however not error
-		ctx, _ = getStatefulContext(ctx, i.firebaseClient, i.crClient)
+		ctx := withProcedure(w.Context(), info.FullMethod)
+		ctx = withRequestID(ctx)
+		if id, err := apictx.RequestIDFromContext(ctx); err == nil {
+			_ = ss.SetHeader(metadata.Pairs(apimeta.HeaderRequestID, id))
+		}
+		ctx, err := i.statefulContext(ctx, info.FullMethod)
+		if err != nil {
+			return err
+		}
 		w.SetContext(ctx)
 		return handler(srv, w)
 	}
@@ -222,26 +454,13 @@ func printMetadata(id string, ctx context.Context) {
 		}
 	}
 	fmt.Fprintf(os.Stdout, "values:\n")
-	if iface := ctx.Value(apictx.ContextKeyNamespace{}); iface != nil {
-		fmt.Fprintf(os.Stdout, "\tContextValueNamespace: %v\n", iface)
-	}
-	if iface := ctx.Value(apictx.ContextKeyProcedure{}); iface != nil {
-		fmt.Fprintf(os.Stdout, "\tContextKeyProcedure: %v\n", iface)
-	}
-	if iface := ctx.Value(apictx.ContextKeySubscription{}); iface != nil {
-		fmt.Fprintf(os.Stdout, "\tContextKeySubscription: %v\n", iface)
-	}
-	if iface := ctx.Value(apictx.ContextKeyToken{}); iface != nil {
-		fmt.Fprintf(os.Stdout, "\tContextKeyToken: %v\n", iface)
-	}
-	if iface := ctx.Value(apictx.ContextKeyUser{}); iface != nil {
-		fmt.Fprintf(os.Stdout, "\tContextKeyUser: %v\n", iface)
-	}
-	if iface := ctx.Value(apictx.ContextKeyWorkspace{}); iface != nil {
-		fmt.Fprintf(os.Stdout, "\tContextKeyWorkspace: %v\n", iface)
-	}
-	if iface := ctx.Value(apictx.ContextKeyQualifiedWorkspace{}); iface != nil {
-		fmt.Fprintf(os.Stdout, "\tContextKeyQualifiedWorkspace: %v\n", iface)
-	}
+	state := apictx.FromContext(ctx)
+	fmt.Fprintf(os.Stdout, "\tNamespace: %v\n", state.Namespace)
+	fmt.Fprintf(os.Stdout, "\tProcedure: %v\n", state.Procedure)
+	fmt.Fprintf(os.Stdout, "\tSubscription: %v\n", state.Subscription)
+	fmt.Fprintf(os.Stdout, "\tToken: %v\n", state.Token)
+	fmt.Fprintf(os.Stdout, "\tUser: %v\n", state.User)
+	fmt.Fprintf(os.Stdout, "\tWorkspace: %v\n", state.Workspace)
+	fmt.Fprintf(os.Stdout, "\tQualifiedWorkspace: %v\n", state.QualifiedWorkspace)
 	fmt.Fprintf(os.Stdout, "\n")
 }
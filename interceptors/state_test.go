@@ -0,0 +1,389 @@
+package interceptors
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	fbauth "firebase.google.com/go/auth"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	apictx "github.com/drud/api-common/context"
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func testNamespace(name, subscription, workspace string) *v1.Namespace {
+	return &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				"ddev.live/displayname":          workspace,
+				apimeta.LabelKeySubscriptionStub: subscription,
+			},
+		},
+	}
+}
+
+func testInterceptor(objs ...runtime.Object) *interceptor {
+	verifier := StaticTokenVerifier{
+		"token-alice": &fbauth.Token{UID: "alice", Claims: map[string]interface{}{}},
+		"token-bob": &fbauth.Token{UID: "bob", Claims: map[string]interface{}{
+			apimeta.ClaimKeyDefaultWorkspace: "acme.prod",
+		}},
+	}
+	resolver := StaticUserResolver{
+		"alice": &fbauth.UserRecord{UserInfo: &fbauth.UserInfo{UID: "alice"}},
+		"bob":   &fbauth.UserRecord{UserInfo: &fbauth.UserInfo{UID: "bob"}},
+	}
+	return NewStateInterceptorWithVerifier(verifier, resolver, fake.NewFakeClient(objs...)).(*interceptor)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	i := testInterceptor(
+		testNamespace("ns-acme-prod", "acme", "prod"),
+		testNamespace("ns-acme-dup1", "acme", "dup"),
+		testNamespace("ns-acme-dup2", "acme", "dup"),
+	)
+
+	tests := []struct {
+		name          string
+		md            metadata.MD
+		wantUser      string
+		wantNamespace string
+	}{
+		{
+			name:          "qualified workspace",
+			md:            metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.prod"),
+			wantUser:      "alice",
+			wantNamespace: "ns-acme-prod",
+		},
+		{
+			name:          "default workspace claim",
+			md:            metadata.Pairs(apimeta.HeaderAuthToken, "token-bob"),
+			wantUser:      "bob",
+			wantNamespace: "ns-acme-prod",
+		},
+		{
+			name:          "unqualified workspace",
+			md:            metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "legacy"),
+			wantUser:      "alice",
+			wantNamespace: "legacy",
+		},
+		{
+			name:          "ambiguous workspace",
+			md:            metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.dup"),
+			wantUser:      "alice",
+			wantNamespace: "",
+		},
+		{
+			name:          "invalid token",
+			md:            metadata.Pairs(apimeta.HeaderAuthToken, "token-mallory", apimeta.HeaderWorkspace, "acme.prod"),
+			wantUser:      "",
+			wantNamespace: "ns-acme-prod",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				user, _ := apictx.UserFromContext(ctx)
+				if user != tt.wantUser {
+					t.Errorf("UserFromContext() = %q, want %q", user, tt.wantUser)
+				}
+				ns, _ := apictx.NamespaceFromContext(ctx)
+				if ns != tt.wantNamespace {
+					t.Errorf("NamespaceFromContext() = %q, want %q", ns, tt.wantNamespace)
+				}
+				procedure, _ := apictx.ProcedureFromContext(ctx)
+				if procedure != info.FullMethod {
+					t.Errorf("ProcedureFromContext() = %q, want %q", procedure, info.FullMethod)
+				}
+				return nil, nil
+			}
+			if _, err := i.UnaryServerInterceptor()(ctx, nil, info, handler); err != nil {
+				t.Errorf("UnaryServerInterceptor() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestEnforcement(t *testing.T) {
+	i := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+	WithEnforcement()(i)
+	WithAllowlist("/grpc.health.v1.Health/*")(i)
+
+	tests := []struct {
+		name     string
+		method   string
+		md       metadata.MD
+		wantCode codes.Code
+	}{
+		{
+			name:     "authenticated",
+			method:   "/test.Service/Method",
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.OK,
+		},
+		{
+			name:     "unauthenticated",
+			method:   "/test.Service/Method",
+			md:       metadata.Pairs(apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "invalid token",
+			method:   "/test.Service/Method",
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-mallory", apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "unknown workspace",
+			method:   "/test.Service/Method",
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.staging"),
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "no workspace",
+			method:   "/test.Service/Method",
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-alice"),
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "allowlisted",
+			method:   "/grpc.health.v1.Health/Check",
+			md:       metadata.MD{},
+			wantCode: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			info := &grpc.UnaryServerInfo{FullMethod: tt.method}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			}
+			_, err := i.UnaryServerInterceptor()(ctx, nil, info, handler)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("UnaryServerInterceptor() code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestMembership(t *testing.T) {
+	labelled := testNamespace("ns-acme-prod", "acme", "prod")
+	labelled.Labels[apimeta.LabelKeyMemberPrefix+"alice"] = "true"
+	annotated := testNamespace("ns-acme-dev", "acme", "dev")
+	annotated.Annotations = map[string]string{apimeta.AnnotationKeyMembers: "carol, bob"}
+	i := testInterceptor(labelled, annotated)
+	WithEnforcement()(i)
+	WithMembershipVerifier(NewNamespaceMembership(i.crClient))(i)
+
+	tests := []struct {
+		name          string
+		md            metadata.MD
+		wantCode      codes.Code
+		wantNamespace string
+	}{
+		{
+			name:          "member by label",
+			md:            metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode:      codes.OK,
+			wantNamespace: "ns-acme-prod",
+		},
+		{
+			name:          "member by annotation",
+			md:            metadata.Pairs(apimeta.HeaderAuthToken, "token-bob", apimeta.HeaderWorkspace, "acme.dev"),
+			wantCode:      codes.OK,
+			wantNamespace: "ns-acme-dev",
+		},
+		{
+			name:     "cross tenant",
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-bob", apimeta.HeaderWorkspace, "acme.prod"),
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "unqualified namespace",
+			md:       metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "kube-system"),
+			wantCode: codes.PermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				ns, _ := apictx.NamespaceFromContext(ctx)
+				if ns != tt.wantNamespace {
+					t.Errorf("NamespaceFromContext() = %q, want %q", ns, tt.wantNamespace)
+				}
+				return nil, nil
+			}
+			_, err := i.UnaryServerInterceptor()(ctx, nil, info, handler)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("UnaryServerInterceptor() code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestLazyResolution(t *testing.T) {
+	i := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+	records := &countingResolver{}
+	i.resolver = records
+	WithLazyResolution()(i)
+
+	md := metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.prod")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		if user, err := apictx.UserFromContext(ctx); err != nil || user != "alice" {
+			t.Errorf("UserFromContext() = %q, %v", user, err)
+		}
+		if calls := atomic.LoadInt32(&records.calls); calls != 0 {
+			t.Errorf("user record retrieved %d times before it was requested", calls)
+		}
+		var wg sync.WaitGroup
+		for n := 0; n < 5; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if record, err := apictx.UserRecordFromContext(ctx); err != nil || record.UID != "alice" {
+					t.Errorf("UserRecordFromContext() = %v, %v", record, err)
+				}
+			}()
+		}
+		wg.Wait()
+		if calls := atomic.LoadInt32(&records.calls); calls != 1 {
+			t.Errorf("user record retrieved %d times, want 1", calls)
+		}
+		if ns, err := apictx.NamespaceFromContext(ctx); err != nil || ns != "ns-acme-prod" {
+			t.Errorf("NamespaceFromContext() = %q, %v", ns, err)
+		}
+		return nil, nil
+	}
+	if _, err := i.UnaryServerInterceptor()(ctx, nil, info, handler); err != nil {
+		t.Errorf("UnaryServerInterceptor() error = %v", err)
+	}
+
+	// Failed resolution reports the same errors as eager resolution
+	md = metadata.Pairs(apimeta.HeaderAuthToken, "token-mallory", apimeta.HeaderWorkspace, "acme.staging")
+	ctx = metadata.NewIncomingContext(context.Background(), md)
+	handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+		if _, err := apictx.UserFromContext(ctx); status.Code(err) != codes.NotFound {
+			t.Errorf("UserFromContext() error = %v, want NotFound", err)
+		}
+		if _, err := apictx.NamespaceFromContext(ctx); status.Code(err) != codes.NotFound {
+			t.Errorf("NamespaceFromContext() error = %v, want NotFound", err)
+		}
+		if sub, err := apictx.SubscriptionFromContext(ctx); err != nil || sub != "acme" {
+			t.Errorf("SubscriptionFromContext() = %q, %v", sub, err)
+		}
+		return nil, nil
+	}
+	if _, err := i.UnaryServerInterceptor()(ctx, nil, info, handler); err != nil {
+		t.Errorf("UnaryServerInterceptor() error = %v", err)
+	}
+}
+
+func TestAuthorizationSchemes(t *testing.T) {
+	metrics := NewMetrics(MetricsConfig{})
+	keys := StaticTokenVerifier{
+		"a2V5OnNlY3JldA==": &fbauth.Token{UID: "alice", Claims: map[string]interface{}{}},
+	}
+	strict := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+	WithSchemeVerifier("Basic", keys)(strict)
+	WithMetrics(metrics)(strict)
+	legacy := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+	WithLegacyAuthorization()(legacy)
+	WithMetrics(metrics)(legacy)
+
+	tests := []struct {
+		name          string
+		i             *interceptor
+		authorization string
+		wantCode      codes.Code
+		wantBearer    string
+	}{
+		{"bearer", strict, "Bearer token-alice", codes.OK, "token-alice"},
+		{"basic", strict, "basic a2V5OnNlY3JldA==", codes.OK, ""},
+		{"basic invalid", strict, "Basic b3RoZXI6c2VjcmV0", codes.Unauthenticated, ""},
+		{"unsupported scheme", strict, "Digest token-alice", codes.Unauthenticated, ""},
+		{"legacy rejected", strict, "token-alice", codes.Unauthenticated, ""},
+		{"legacy accepted", legacy, "token-alice", codes.OK, "token-alice"},
+		{"basic without verifier", legacy, "Basic a2V5OnNlY3JldA==", codes.Unauthenticated, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			WithEnforcement()(tt.i)
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+				"authorization", tt.authorization, apimeta.HeaderWorkspace, "acme.prod"))
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				if user, err := apictx.UserFromContext(ctx); err != nil || user != "alice" {
+					t.Errorf("UserFromContext() = %q, %v", user, err)
+				}
+				if bearer, _ := apictx.BearerFromContext(ctx); bearer != tt.wantBearer {
+					t.Errorf("BearerFromContext() = %q, want %q", bearer, tt.wantBearer)
+				}
+				return nil, nil
+			}
+			_, err := tt.i.UnaryServerInterceptor()(ctx, nil, info, handler)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("UnaryServerInterceptor() code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+		})
+	}
+
+	for accepted, want := range map[string]float64{"false": 1, "true": 1} {
+		if got := testutil.ToFloat64(metrics.legacyAuthorization.WithLabelValues(accepted)); got != want {
+			t.Errorf("legacy authorization accepted=%s = %v, want %v", accepted, got, want)
+		}
+	}
+}
+
+func TestWorkspaceScope(t *testing.T) {
+	i := testInterceptor(
+		testNamespace("ns-acme-prod", "acme", "prod"),
+		testNamespace("ns-acme-dev", "acme", "dev"),
+	)
+	WithEnforcement()(i)
+	i.verifier.(StaticTokenVerifier)["token-ci"] = &fbauth.Token{UID: "alice", Claims: map[string]interface{}{
+		apimeta.ClaimKeyWorkspaces:       []interface{}{"acme.prod"},
+		apimeta.ClaimKeyDefaultWorkspace: "acme.prod",
+	}}
+
+	tests := []struct {
+		name     string
+		md       metadata.MD
+		wantCode codes.Code
+	}{
+		{"in scope", metadata.Pairs(apimeta.HeaderAuthToken, "token-ci", apimeta.HeaderWorkspace, "acme.prod"), codes.OK},
+		{"default workspace", metadata.Pairs(apimeta.HeaderAuthToken, "token-ci"), codes.OK},
+		{"out of scope", metadata.Pairs(apimeta.HeaderAuthToken, "token-ci", apimeta.HeaderWorkspace, "acme.dev"), codes.PermissionDenied},
+		{"unqualified workspace", metadata.Pairs(apimeta.HeaderAuthToken, "token-ci", apimeta.HeaderWorkspace, "ns-acme-dev"), codes.PermissionDenied},
+		{"unscoped token", metadata.Pairs(apimeta.HeaderAuthToken, "token-alice", apimeta.HeaderWorkspace, "acme.dev"), codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+			_, err := i.UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("UnaryServerInterceptor() code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+		})
+	}
+}
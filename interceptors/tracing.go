@@ -0,0 +1,143 @@
+package interceptors
+
+import (
+	"context"
+	"sort"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apictx "github.com/drud/api-common/context"
+)
+
+const instrumentationName = "github.com/drud/api-common/interceptors"
+
+var (
+	workspaceKey    = attribute.Key("ddev.workspace")
+	subscriptionKey = attribute.Key("ddev.subscription")
+	namespaceKey    = attribute.Key("ddev.namespace")
+	grpcStatusKey   = attribute.Key("rpc.grpc.status_code")
+)
+
+// metadataCarrier adapts gRPC metadata for propagating the trace context
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// tracerFrom returns the tracer of the provider, or of the global provider when nil
+func tracerFrom(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(instrumentationName)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+// setSpanAttributes describes the request state resolved so far on the RPC span, lazy fields are not resolved
+func setSpanAttributes(ctx context.Context) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	state := apictx.FromContext(ctx)
+	var attrs []attribute.KeyValue
+	add := func(key attribute.Key, value string) {
+		if value != "" {
+			attrs = append(attrs, key.String(value))
+		}
+	}
+	add(semconv.EnduserIDKey, state.User)
+	if state.QualifiedWorkspace != "" {
+		add(workspaceKey, state.QualifiedWorkspace)
+	} else {
+		add(workspaceKey, state.Workspace)
+	}
+	add(subscriptionKey, state.Subscription)
+	add(namespaceKey, state.Namespace)
+	span.SetAttributes(attrs...)
+}
+
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracingInterceptor starts a server span for every RPC continuing the W3C trace context of the incoming metadata,
+// the global tracer provider is used when tp is nil. Install it before the state interceptor so the span covers
+// resolving the request state.
+func NewTracingInterceptor(tp trace.TracerProvider) StateInterceptors {
+	return &tracing{
+		tracer:     tracerFrom(tp),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+func (t *tracing) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = t.propagator.Extract(ctx, metadataCarrier(md))
+	}
+	return t.tracer.Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.RPCSystemKey.String("grpc")),
+	)
+}
+
+func (t *tracing) end(span trace.Span, err error) {
+	span.SetAttributes(grpcStatusKey.Int(int(status.Code(err))))
+	endSpan(span, err)
+}
+
+func (t *tracing) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := t.start(ctx, info.FullMethod)
+		resp, err := handler(ctx, req)
+		t.end(span, err)
+		return resp, err
+	}
+}
+
+func (t *tracing) StreamingServerInterceptor() grpc.StreamServerInterceptor {
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := t.start(ss.Context(), info.FullMethod)
+		w := newStreamContextWrapper(ss)
+		w.SetContext(ctx)
+		err := handler(srv, w)
+		t.end(span, err)
+		return err
+	}
+}
@@ -0,0 +1,80 @@
+package interceptors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	apimeta "github.com/drud/api-common/metadata"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := new(oteltest.SpanRecorder)
+	tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(recorder))
+	i := testInterceptor(testNamespace("ns-acme-prod", "acme", "prod"))
+	WithTracerProvider(tp)(i)
+	tracing := NewTracingInterceptor(tp)
+	client := NewClientInterceptor()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	md := metadata.Pairs(
+		apimeta.HeaderAuthToken, "token-alice",
+		apimeta.HeaderWorkspace, "acme.prod",
+		"traceparent", "00-"+traceID+"-00f067aa0ba902b7-01",
+	)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	var outgoing metadata.MD
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			outgoing, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		}
+		return nil, client.UnaryClientInterceptor()(ctx, "/test.Other/Method", nil, nil, nil, invoker)
+	}
+	chain := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return i.UnaryServerInterceptor()(ctx, req, info, handler)
+	}
+	if _, err := tracing.UnaryServerInterceptor()(metadata.NewIncomingContext(context.Background(), md), nil, info, chain); err != nil {
+		t.Fatalf("UnaryServerInterceptor() error = %v", err)
+	}
+
+	spans := make(map[string]*oteltest.Span)
+	for _, span := range recorder.Completed() {
+		spans[span.Name()] = span
+	}
+	rpc, ok := spans[info.FullMethod]
+	if !ok {
+		t.Fatalf("spans = %v, want RPC span", spans)
+	}
+	if got := rpc.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("RPC span trace ID = %s, want %s", got, traceID)
+	}
+	if rpc.SpanKind() != trace.SpanKindServer {
+		t.Errorf("RPC span kind = %v, want server", rpc.SpanKind())
+	}
+	attrs := rpc.Attributes()
+	if attrs[semconv.EnduserIDKey].AsString() != "alice" || attrs[namespaceKey].AsString() != "ns-acme-prod" || attrs[workspaceKey].AsString() != "acme.prod" {
+		t.Errorf("RPC span attributes = %v", attrs)
+	}
+	for _, name := range []string{"VerifyToken", "GetUserRecord", "ResolveWorkspace"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("spans = %v, want %s span", spans, name)
+			continue
+		}
+		if span.ParentSpanID() != rpc.SpanContext().SpanID() {
+			t.Errorf("%s span parent = %s, want RPC span %s", name, span.ParentSpanID(), rpc.SpanContext().SpanID())
+		}
+	}
+
+	traceparent := outgoing.Get("traceparent")
+	if len(traceparent) != 1 || !strings.Contains(traceparent[0], traceID) || !strings.Contains(traceparent[0], rpc.SpanContext().SpanID().String()) {
+		t.Errorf("outgoing traceparent = %v, want RPC span of trace %s", traceparent, traceID)
+	}
+}
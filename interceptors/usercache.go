@@ -0,0 +1,143 @@
+package interceptors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	fbauth "firebase.google.com/go/auth"
+	"github.com/golang/groupcache/lru"
+	"github.com/golang/groupcache/singleflight"
+)
+
+// UserCacheConfig configures a CachedUserResolver
+type UserCacheConfig struct {
+	// TTL is how long a retrieved user record is served from the cache, defaults to DefaultUserCacheTTL
+	TTL time.Duration
+	// NegativeTTL is how long an unknown UID is remembered, zero disables negative caching
+	NegativeTTL time.Duration
+	// MaxEntries bounds the cache size, evicting the least recently used entries, defaults to DefaultUserCacheEntries
+	MaxEntries int
+	// IsNotFound identifies errors for unknown UIDs, defaults to fbauth.IsUserNotFound
+	IsNotFound func(error) bool
+	// LookupTimeout bounds a lookup shared by concurrent callers, defaults to DefaultUserLookupTimeout
+	LookupTimeout time.Duration
+}
+
+const (
+	// DefaultUserCacheTTL is how long a CachedUserResolver serves records by default
+	DefaultUserCacheTTL = time.Minute
+	// DefaultUserCacheEntries bounds the entries of a CachedUserResolver by default
+	DefaultUserCacheEntries = 10000
+	// DefaultUserLookupTimeout bounds the lookups of a CachedUserResolver by default
+	DefaultUserLookupTimeout = 10 * time.Second
+)
+
+// detachedContext keeps the values of a context, e.g. the request state and span, without its cancellation
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+type userEntry struct {
+	record  *fbauth.UserRecord
+	err     error
+	expires time.Time
+}
+
+// CachedUserResolver is a UserResolver caching user records from an underlying resolver.
+// Concurrent lookups of the same UID share a single request to the underlying resolver.
+type CachedUserResolver struct {
+	resolver UserResolver
+	config   UserCacheConfig
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache *lru.Cache
+	group singleflight.Group
+}
+
+// NewCachedUserResolver wraps a UserResolver with a cache
+func NewCachedUserResolver(resolver UserResolver, config UserCacheConfig) *CachedUserResolver {
+	if config.IsNotFound == nil {
+		config.IsNotFound = fbauth.IsUserNotFound
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultUserCacheTTL
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultUserCacheEntries
+	}
+	if config.LookupTimeout <= 0 {
+		config.LookupTimeout = DefaultUserLookupTimeout
+	}
+	return &CachedUserResolver{
+		resolver: resolver,
+		config:   config,
+		now:      time.Now,
+		cache:    lru.New(config.MaxEntries),
+	}
+}
+
+func (c *CachedUserResolver) GetUser(ctx context.Context, uid string) (*fbauth.UserRecord, error) {
+	if entry, ok := c.lookup(uid); ok {
+		return entry.record, entry.err
+	}
+	iface, err := c.group.Do(uid, func() (interface{}, error) {
+		// Another caller may have populated the entry while this one was waiting
+		if entry, ok := c.lookup(uid); ok {
+			return entry.record, entry.err
+		}
+		// The lookup is shared, so is not cancelled with the request of the caller which started it
+		lookupCtx, cancel := context.WithTimeout(detachedContext{ctx}, c.config.LookupTimeout)
+		defer cancel()
+		record, err := c.resolver.GetUser(lookupCtx, uid)
+		if err == nil {
+			c.store(uid, userEntry{record: record, expires: c.now().Add(c.config.TTL)})
+		} else if c.config.NegativeTTL > 0 && c.config.IsNotFound(err) {
+			c.store(uid, userEntry{err: err, expires: c.now().Add(c.config.NegativeTTL)})
+		}
+		return record, err
+	})
+	record, _ := iface.(*fbauth.UserRecord)
+	return record, err
+}
+
+// Invalidate drops the cached record for a UID, e.g. after its custom claims are updated
+func (c *CachedUserResolver) Invalidate(uid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache.Remove(uid)
+}
+
+func (c *CachedUserResolver) lookup(uid string) (userEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	iface, ok := c.cache.Get(uid)
+	if !ok {
+		return userEntry{}, false
+	}
+	entry := iface.(userEntry)
+	if !c.now().Before(entry.expires) {
+		c.cache.Remove(uid)
+		return userEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *CachedUserResolver) store(uid string, entry userEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache.Add(uid, entry)
+}
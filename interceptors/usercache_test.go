@@ -0,0 +1,167 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fbauth "firebase.google.com/go/auth"
+)
+
+var errTestUserNotFound = errors.New("user not found")
+
+type countingResolver struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (c *countingResolver) GetUser(ctx context.Context, uid string) (*fbauth.UserRecord, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if c.release != nil {
+		<-c.release
+	}
+	if uid == "unknown" {
+		return nil, errTestUserNotFound
+	}
+	return &fbauth.UserRecord{UserInfo: &fbauth.UserInfo{UID: uid}}, nil
+}
+
+func TestCachedUserResolver(t *testing.T) {
+	inner := &countingResolver{}
+	cache := NewCachedUserResolver(inner, UserCacheConfig{
+		TTL:         time.Minute,
+		NegativeTTL: time.Second,
+		MaxEntries:  2,
+		IsNotFound:  func(err error) bool { return err == errTestUserNotFound },
+	})
+	clock := time.Now()
+	cache.now = func() time.Time { return clock }
+	ctx := context.Background()
+
+	lookup := func(uid string, wantCalls int32) {
+		t.Helper()
+		record, err := cache.GetUser(ctx, uid)
+		if uid == "unknown" {
+			if err != errTestUserNotFound {
+				t.Errorf("GetUser(%s) error = %v", uid, err)
+			}
+		} else if err != nil || record.UID != uid {
+			t.Errorf("GetUser(%s) = %v, %v", uid, record, err)
+		}
+		if calls := atomic.LoadInt32(&inner.calls); calls != wantCalls {
+			t.Errorf("GetUser(%s) underlying calls = %d, want %d", uid, calls, wantCalls)
+		}
+	}
+
+	lookup("alice", 1)
+	lookup("alice", 1)
+	lookup("unknown", 2)
+	lookup("unknown", 2)
+	// alice is the least recently used entry and is evicted
+	lookup("bob", 3)
+	lookup("alice", 4)
+	// the negative entry expires before the positive entries
+	clock = clock.Add(2 * time.Second)
+	lookup("unknown", 5)
+	lookup("alice", 5)
+	clock = clock.Add(time.Minute)
+	lookup("alice", 6)
+}
+
+func TestCachedUserResolverDefaults(t *testing.T) {
+	inner := &countingResolver{}
+	cache := NewCachedUserResolver(inner, UserCacheConfig{})
+	clock := time.Now()
+	cache.now = func() time.Time { return clock }
+
+	if cache.cache.MaxEntries != DefaultUserCacheEntries {
+		t.Errorf("MaxEntries = %d, want %d", cache.cache.MaxEntries, DefaultUserCacheEntries)
+	}
+	// Records are cached for the default TTL rather than expiring immediately
+	for n := 0; n < 2; n++ {
+		if _, err := cache.GetUser(context.Background(), "alice"); err != nil {
+			t.Fatalf("GetUser() error = %v", err)
+		}
+	}
+	if calls := atomic.LoadInt32(&inner.calls); calls != 1 {
+		t.Errorf("underlying calls = %d, want 1", calls)
+	}
+	clock = clock.Add(DefaultUserCacheTTL)
+	if _, err := cache.GetUser(context.Background(), "alice"); err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	if calls := atomic.LoadInt32(&inner.calls); calls != 2 {
+		t.Errorf("underlying calls after the default TTL = %d, want 2", calls)
+	}
+}
+
+func TestCachedUserResolverSingleFlight(t *testing.T) {
+	inner := &countingResolver{release: make(chan struct{})}
+	cache := NewCachedUserResolver(inner, UserCacheConfig{TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GetUser(context.Background(), "alice"); err != nil {
+				t.Errorf("GetUser() error = %v", err)
+			}
+		}()
+	}
+	// Give the lookups time to queue behind the first before releasing it
+	time.Sleep(50 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+	if calls := atomic.LoadInt32(&inner.calls); calls != 1 {
+		t.Errorf("underlying calls = %d, want 1", calls)
+	}
+}
+
+type contextResolver struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *contextResolver) GetUser(ctx context.Context, uid string) (*fbauth.UserRecord, error) {
+	close(c.started)
+	select {
+	case <-c.release:
+		return &fbauth.UserRecord{UserInfo: &fbauth.UserInfo{UID: uid}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestCachedUserResolverCancellation(t *testing.T) {
+	inner := &contextResolver{started: make(chan struct{}), release: make(chan struct{})}
+	cache := NewCachedUserResolver(inner, UserCacheConfig{TTL: time.Minute})
+
+	// The first caller gives up while a second waits on the shared lookup
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.GetUser(ctx, "alice")
+		first <- err
+	}()
+	<-inner.started
+	second := make(chan error, 1)
+	go func() {
+		_, err := cache.GetUser(context.Background(), "alice")
+		second <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	close(inner.release)
+
+	if err := <-second; err != nil {
+		t.Errorf("GetUser() waiting caller error = %v", err)
+	}
+	if err := <-first; err != nil {
+		t.Errorf("GetUser() cancelled caller error = %v", err)
+	}
+}
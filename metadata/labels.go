@@ -8,11 +8,27 @@ const (
 	LabelKeyCustomer = "ddev.live/customer"
 	// Indiciates the workspace the resource belongs to
 	LabelKeyWorkspace = "ddev.live/workspace"
+	// Prefix of labels marking the UID in the key suffix as a member of the workspace
+	LabelKeyMemberPrefix = "member.ddev.live/"
+	// Lists the comma separated UIDs which are members of the workspace
+	AnnotationKeyMembers = "ddev.live/members"
 
 	ClaimKeyDefaultWorkspace = "default_workspace"
+	// Grants support staff the ability to impersonate users
+	ClaimKeyAdmin = "admin"
+	// Lists the qualified workspaces a token is limited to, e.g. for workspace scoped API keys
+	ClaimKeyWorkspaces = "workspaces"
+	// Identifies the API key a token was derived from
+	ClaimKeyAPIKey = "api_key"
 
 	// Indicates the firebase token for the request
 	HeaderAuthToken = "x-auth-token"
 	// Indicates the workspace scope for the request
 	HeaderWorkspace = "x-ddev-workspace"
+	// Indicates the user a service is calling on behalf of
+	HeaderOnBehalfOf = "x-ddev-on-behalf-of"
+	// Indicates the user a member of support staff is impersonating
+	HeaderImpersonateUser = "x-ddev-impersonate-user"
+	// Correlates the logs and audit records of a request
+	HeaderRequestID = "x-request-id"
 )
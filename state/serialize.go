@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sync"
 
 	"cloud.google.com/go/firestore"
 	firestorepb "google.golang.org/genproto/googleapis/firestore/v1"
@@ -31,15 +32,23 @@ const (
 	CollectionPlan CollectionName = "plans"
 	// CollectionProducts - the collection to serialize stripe product proto messages to
 	CollectionProducts CollectionName = "products"
+	// CollectionAPIKey - the collection to serialize api key proto messages to
+	CollectionAPIKey CollectionName = "apikeys"
 )
 
-var projectID string
-
-func init() {
+var (
+	projectOnce sync.Once
+	projectID   string
+)
 
-	if project := os.Getenv("PROJECT_ID"); project != "" {
-		projectID = project
-	} else {
+// ProjectID returns the google project of the Firestore database, read from the PROJECT_ID environment variable or
+// else the metadata service the first time it is required
+func ProjectID() string {
+	projectOnce.Do(func() {
+		if project := os.Getenv("PROJECT_ID"); project != "" {
+			projectID = project
+			return
+		}
 		// Retrieve the projectID from the metadata service
 		req, err := http.NewRequest(http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/project/project-id", nil)
 		if err != nil {
@@ -57,7 +66,8 @@ func init() {
 			klog.Fatalf("Could not parse google project id: %v", err)
 		}
 		projectID = string(data)
-	}
+	})
+	return projectID
 }
 
 type ProtoState struct {
@@ -83,7 +93,7 @@ type ProtoSubscription interface {
 }
 
 func GetDatabasePath() string {
-	return fmt.Sprintf("projects/%s/databases/(default)", projectID)
+	return fmt.Sprintf("projects/%s/databases/(default)", ProjectID())
 }
 
 func RemoveSerialized(c firestore.Client, txn *firestore.Transaction, collection CollectionName, state protoreflect.ProtoMessage) error {
@@ -247,6 +257,20 @@ func serializeNamed(txn *firestore.Transaction, collection string, state ProtoNa
 	return ref, nil
 }
 
+// DeserializeSnapshot unmarshals the raw proto message stored in a document snapshot
+func DeserializeSnapshot(snap *firestore.DocumentSnapshot, msg protoreflect.ProtoMessage) error {
+
+	raw, err := snap.DataAt(DataPathRaw)
+	if err != nil {
+		return err
+	}
+	data, ok := raw.([]byte)
+	if !ok {
+		return fmt.Errorf("document %s has no raw proto", snap.Ref.ID)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
 func Deserialize(doc *firestorepb.Document, msg protoreflect.ProtoMessage) error {
 
 	if raw, ok := doc.Fields[DataPathRaw]; ok {
@@ -0,0 +1,27 @@
+package state
+
+import (
+	"os"
+	"testing"
+)
+
+func TestProjectID(t *testing.T) {
+	// The project id is only resolved when first required, so loading the package needs neither PROJECT_ID nor the
+	// metadata service
+	if projectID != "" {
+		t.Fatalf("project id %q resolved before it was required", projectID)
+	}
+	previous, set := os.LookupEnv("PROJECT_ID")
+	defer func() {
+		if set {
+			os.Setenv("PROJECT_ID", previous)
+		} else {
+			os.Unsetenv("PROJECT_ID")
+		}
+	}()
+	os.Setenv("PROJECT_ID", "test-project")
+
+	if got := GetDatabasePath(); got != "projects/test-project/databases/(default)" {
+		t.Errorf("GetDatabasePath() = %q", got)
+	}
+}